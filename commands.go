@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alexflint/go-arg"
+	"os"
+	"path/filepath"
+	"time"
+	"tjweldon/spider/src/config"
+	"tjweldon/spider/src/scope"
+)
+
+// Options are the settings shared by every command that crawls
+type Options struct {
+	Targets   []string `arg:"positional" help:"The urls to start the swarm off at."`
+	SeedsFile string   `arg:"--seeds-file" help:"A file of urls to start the swarm off at, one per line and optionally followed by a scope for that url, - for stdin."`
+	Config    string   `arg:"-c,--config" help:"A YAML or TOML file describing the crawl. Flags given on the command line override its settings."`
+
+	// Settings that only a config file can give
+	Seeds    []string      `arg:"-"`
+	Rewrites []config.Rule `arg:"-"`
+	Scrapers []string      `arg:"-"`
+
+	// Scope
+	Scope      string   `arg:"--scope" default:"domain" help:"How far from each target to crawl, unless the seeds file says otherwise: any, host, domain, subdomain or prefix."`
+	AllowHosts []string `arg:"--allow-host,separate" help:"A host (and its subdomains) to crawl regardless of --scope."`
+	DenyHosts  []string `arg:"--deny-host,separate" help:"A host (and its subdomains) never to crawl."`
+	Include    []string `arg:"--include,separate" help:"A regular expression, one of which urls must match to be crawled."`
+	Exclude    []string `arg:"--exclude,separate" help:"A regular expression that stops matching urls being crawled."`
+
+	// Depth and size
+	MaxJobs  int `arg:"-l,--limit" default:"256" help:"The number of urls the swarm will visit, 0 for no limit."`
+	MaxDepth int `arg:"--max-depth" default:"-1" help:"The number of links to follow away from the target, negative for no limit."`
+
+	// Concurrency and politeness
+	Workers      int           `arg:"-w,--workers" default:"5" help:"The number of crawlers the swarm starts with."`
+	MinWorkers   int           `arg:"--min-workers" default:"1" help:"The fewest crawlers the swarm will scale down to."`
+	MaxWorkers   int           `arg:"--max-workers" default:"0" help:"The most crawlers the swarm will scale up to, no scaling if not above --workers."`
+	MaxLatency   time.Duration `arg:"--max-latency" default:"0s" help:"Scale down while pages take longer than this to crawl on average, 0 to ignore latency."`
+	Delay        time.Duration `arg:"--delay" default:"250ms" help:"The minimum time between requests to the same host."`
+	PerHost      int           `arg:"--per-host" default:"2" help:"The maximum number of concurrent requests to the same host, 0 for no limit."`
+	UserAgent    string        `arg:"--user-agent" default:"spider" help:"The user agent used to identify the swarm, and to pick rules out of robots.txt."`
+	IgnoreRobots bool          `arg:"--ignore-robots" help:"Crawl without fetching or honouring robots.txt."`
+
+	// Fetching
+	Timeout      time.Duration `arg:"--timeout" default:"0s" help:"The longest the whole crawl may take, 0 for no limit."`
+	FetchTimeout time.Duration `arg:"--fetch-timeout" default:"30s" help:"The longest a single page may take to download."`
+	MaxBody      int64         `arg:"--max-body" default:"10485760" help:"The most bytes of each page that are downloaded."`
+	MaxAttempts  int           `arg:"--max-attempts" default:"3" help:"The most times a page is fetched before giving up on it."`
+	RetryDelay   time.Duration `arg:"--retry-delay" default:"1s" help:"The delay before the first retry, doubling for each one after that."`
+	MaxRetry     time.Duration `arg:"--max-retry-delay" default:"30s" help:"The longest delay before a retry, including one asked for with Retry-After."`
+	MaxRedirects int           `arg:"--max-redirects" default:"10" help:"The most redirects followed for one page before giving up on it."`
+	HeadFirst    bool          `arg:"--head-first" help:"Check the type of each page with a HEAD request and only download those that can be crawled."`
+
+	// Deduplication
+	StripParams []string `arg:"--strip-param,separate" help:"A query parameter to drop from urls as well as the usual tracking parameters, a trailing * matches a prefix."`
+	FoldSlashes bool     `arg:"--fold-slashes" help:"Treat urls that differ only by a trailing slash as the same page."`
+	Seen        string   `arg:"--seen" default:"hash" help:"How visited urls are remembered: hash, bloom or disk."`
+	SeenFile    string   `arg:"--seen-file" default:"spider.seen" help:"The file used by --seen disk."`
+	FalsePos    float64  `arg:"--false-positives" default:"0.001" help:"The rate at which --seen bloom may wrongly skip a url."`
+
+	// Resuming
+	State      string        `arg:"--state" help:"A directory to keep the crawl's queue, the urls it has seen and the records of the pages it has fetched in, so that the resume command can carry on with it if it stops. Only the crawl command's --report can be carried on."`
+	Checkpoint time.Duration `arg:"--checkpoint" default:"30s" help:"How often the --state is flushed to disk."`
+
+	// Resumed is set when the crawl is being carried on by the resume
+	// command, so that its records are added to rather than replaced
+	Resumed bool `arg:"-"`
+
+	// Output
+	Output  string `arg:"-o,--output" default:"-" help:"The file the report is written to, - for stdout."`
+	Records string `arg:"--records" help:"A file to write a line of JSON to for each page fetched, as the crawl goes, - for stdout."`
+}
+
+// Formatted is embedded by the commands whose reports are JSON
+type Formatted struct {
+	Format string `arg:"--format" default:"json" help:"How the report is written: json, or pretty for indented json."`
+}
+
+// CrawlCmd crawls a site and reports on what was found
+type CrawlCmd struct {
+	Options
+	Formatted
+	Report    string `arg:"--report" default:"domains" help:"The report on the pages crawled: domains or depths."`
+	Redirects bool   `arg:"--redirects" help:"Also report the redirect chains followed."`
+	Sitemaps  bool   `arg:"--sitemaps" help:"Also crawl the pages in the sites' sitemaps, and report the differences between them and the pages linked to."`
+	Analytics bool   `arg:"--analytics" help:"Also report each page's PageRank, links in and out and clicks from a seed, and the dead end pages. With --sitemaps, the orphan pages are found too."`
+}
+
+// CheckLinksCmd crawls a site and checks every link found on it
+type CheckLinksCmd struct {
+	Options
+	Formatted
+}
+
+// SitemapCmd crawls a site and writes a sitemap of the pages found
+type SitemapCmd struct {
+	Options
+	LastMod    bool   `arg:"--lastmod" help:"Give each page's Last-Modified date in the sitemap."`
+	MaxUrls    int    `arg:"--max-urls" default:"50000" help:"The most urls in one sitemap. Beyond this, the sitemaps are written next to --output, which becomes an index of them."`
+	SitemapUrl string `arg:"--sitemap-url" help:"Where the split sitemaps will be served from, the root of the first target by default."`
+}
+
+// GraphCmd crawls a site and exports the graph of the links between its
+// pages
+type GraphCmd struct {
+	Options
+	GraphFormat string `arg:"--graph-format" default:"graphml" help:"How the graph is written: graphml, dot, or csv for an edge list."`
+	ByHost      bool   `arg:"--by-host" help:"Aggregate the pages into their hosts, with edges weighted by the number of links between them."`
+	Nodes       string `arg:"--nodes" help:"With --graph-format csv, a file to write the node list to."`
+}
+
+// ReportCmd reports on the pages recorded by an earlier crawl
+type ReportCmd struct {
+	Formatted
+	Records string `arg:"positional,required" help:"The --records file of the crawl, - for stdin."`
+	Report  string `arg:"--report" default:"domains" help:"The report on the pages recorded: domains or depths."`
+	Output  string `arg:"-o,--output" default:"-" help:"The file the report is written to, - for stdout."`
+}
+
+// ResumeCmd carries on with a crawl that was run with --state
+type ResumeCmd struct {
+	State string `arg:"positional,required" help:"The --state directory of the crawl."`
+}
+
+// Commands are the subcommands that the spider can run
+type Commands struct {
+	Crawl      *CrawlCmd      `arg:"subcommand:crawl" help:"Crawl a site and report on the pages found."`
+	CheckLinks *CheckLinksCmd `arg:"subcommand:check-links" help:"Crawl a site and report its broken links, exiting with status 1 if there are any."`
+	Sitemap    *SitemapCmd    `arg:"subcommand:sitemap" help:"Crawl a site and write a sitemap.xml of its pages."`
+	Graph      *GraphCmd      `arg:"subcommand:graph" help:"Crawl a site and export the graph of its links."`
+	Resume     *ResumeCmd     `arg:"subcommand:resume" help:"Carry on with a crawl that was stopped, from where it left off."`
+	Report     *ReportCmd     `arg:"subcommand:report" help:"Report on the pages recorded by a crawl with --records, without crawling again."`
+}
+
+// stateCommandFile is the file in a --state directory that records the
+// command that started the crawl, and stateRecordsFile the one that the
+// records of the pages fetched are written to
+const (
+	stateCommandFile = "command.json"
+	stateRecordsFile = "records.jsonl"
+)
+
+// savedCommand is the command line of a crawl with --state, and the
+// directory it was run from, so that resume can run it again.
+type savedCommand struct {
+	Dir  string   `json:"dir"`
+	Args []string `json:"args"`
+}
+
+var args Commands
+
+// ReadSeeds returns the seeds the crawl starts from: the targets on the
+// command line, those in the config file and those in the seeds file. Seeds
+// that don't name a scope mode get the one from --scope.
+func (o *Options) ReadSeeds() ([]scope.Seed, error) {
+	mode, err := scope.ParseMode(o.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	var seeds []scope.Seed
+	for _, line := range append(append([]string{}, o.Targets...), o.Seeds...) {
+		seed, err := scope.ParseSeed(line, mode)
+		if err != nil {
+			return nil, err
+		}
+		seeds = append(seeds, seed)
+	}
+
+	if o.SeedsFile != "" {
+		fromFile, err := scope.ReadSeedsFile(o.SeedsFile, mode)
+		if err != nil {
+			return nil, err
+		}
+		seeds = append(seeds, fromFile...)
+	}
+
+	if len(seeds) == 0 {
+		return nil, fmt.Errorf("a target url is required, on the command line, in a seeds file or in the config file")
+	}
+	return seeds, nil
+}
+
+// ScraperEnabled is true if the named scraper should be used, which they all
+// are, apart from dump-html, unless the config file lists them.
+func (o *Options) ScraperEnabled(name string) bool {
+	if o.Scrapers == nil {
+		return name != "dump-html"
+	}
+	for _, enabled := range o.Scrapers {
+		if enabled == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseArgs parses the command line into opts and returns the reports that
+// the command wants. If there is a config file, the command line is parsed
+// again with the file's settings in front of the flags that were given, so
+// that the flags win. The resume command parses the command line saved in
+// the --state directory instead.
+func ParseArgs() Outputs {
+	parser := arg.MustParse(&args)
+	argv := os.Args[1:]
+	resuming := args.Resume != nil
+	if resuming {
+		saved, err := loadCommand(args.Resume.State)
+		if err != nil {
+			parser.Fail(err.Error())
+		}
+		if err := os.Chdir(saved.Dir); err != nil {
+			parser.Fail(err.Error())
+		}
+		argv = saved.Args
+		if err := parser.Parse(argv); err != nil {
+			parser.Fail(err.Error())
+		}
+	}
+	outputs := selectCommand(parser)
+
+	if opts.Config != "" {
+		crawl, err := config.Load(opts.Config)
+		if err != nil {
+			parser.Fail(err.Error())
+		}
+		command := parser.SubcommandNames()[0]
+		expanded := append([]string{command}, crawl.Args(command, argv[1:])...)
+		if err := parser.Parse(append(expanded, argv[1:]...)); err != nil {
+			parser.Fail(err.Error())
+		}
+		outputs = selectCommand(parser)
+		opts.Seeds = crawl.Seeds
+		opts.Rewrites = crawl.Rewrite.Rules
+		opts.Scrapers = crawl.Scrapers
+	}
+
+	if outputs.Format != "" && outputs.Format != "json" && outputs.Format != "pretty" {
+		parser.Fail(fmt.Sprintf("unknown --format %q, expected json or pretty", outputs.Format))
+	}
+	if opts.State != "" {
+		if err := outputs.Resumable(); err != nil {
+			parser.Fail(err.Error())
+		}
+	}
+	if opts.State != "" && !resuming {
+		if err := saveCommand(opts.State, argv); err != nil {
+			parser.Fail(err.Error())
+		}
+	}
+	opts.Resumed = resuming
+	return outputs
+}
+
+// saveCommand records the command line in the --state directory, refusing
+// to if there is already a crawl there.
+func saveCommand(dir string, argv []string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, stateCommandFile)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already holds a crawl, use resume to carry on with it", dir)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(savedCommand{Dir: wd, Args: argv})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// loadCommand reads back the command line saved by saveCommand
+func loadCommand(dir string) (*savedCommand, error) {
+	data, err := os.ReadFile(filepath.Join(dir, stateCommandFile))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not the --state of a crawl: %w", dir, err)
+	}
+	var saved savedCommand
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(dir, stateCommandFile), err)
+	}
+	return &saved, nil
+}
+
+// selectCommand sets opts from the subcommand that was parsed
+func selectCommand(parser *arg.Parser) Outputs {
+	switch {
+	case args.Crawl != nil:
+		opts = args.Crawl.Options
+		return args.Crawl.Outputs()
+	case args.CheckLinks != nil:
+		opts = args.CheckLinks.Options
+		return args.CheckLinks.Outputs()
+	case args.Sitemap != nil:
+		opts = args.Sitemap.Options
+		return args.Sitemap.Outputs()
+	case args.Graph != nil:
+		opts = args.Graph.Options
+		return args.Graph.Outputs()
+	case args.Report != nil:
+		opts = Options{Output: args.Report.Output}
+		return args.Report.Outputs()
+	default:
+		parser.Fail("a command is required: crawl, check-links, sitemap, graph, resume or report")
+		return Outputs{}
+	}
+}
+
+// Outputs are the reports that a command wants from its crawl
+type Outputs struct {
+	// Report is the report on the jobs crawled, domains or depths, or empty
+	// for none
+	Report string
+
+	// Redirects, CheckLinks, Sitemap and Sitemaps are reports on the results
+	// of fetching the jobs. CheckLinks and Sitemaps also need the links found.
+	// Sitemap is nil unless a sitemap is wanted.
+	Redirects  bool
+	CheckLinks bool
+	Sitemap    *SitemapCmd
+	Sitemaps   bool
+
+	// Graph is the export of the links found, nil for none, and Analytics
+	// the analysis of them
+	Graph     *GraphCmd
+	Analytics bool
+
+	// Format is how JSON reports are written
+	Format string
+
+	// FromRecords is the records file that the reports are made from, in
+	// place of a crawl
+	FromRecords string
+}
+
+// Resumable returns an error naming the first report that the resume command
+// couldn't carry on with. Only the report on the jobs crawled can be carried
+// on, since it is made again from the records of the pages fetched before the
+// crawl stopped, and the others need more than those records hold.
+func (o Outputs) Resumable() error {
+	var report string
+	switch {
+	case o.CheckLinks:
+		report = "check-links"
+	case o.Sitemap != nil:
+		report = "sitemap"
+	case o.Graph != nil:
+		report = "graph"
+	case o.Redirects:
+		report = "--redirects"
+	case o.Sitemaps:
+		report = "--sitemaps"
+	case o.Analytics:
+		report = "--analytics"
+	default:
+		return nil
+	}
+	return fmt.Errorf("--state can't be used with %s, as its report can't be carried on by resume", report)
+}
+
+// Outputs is the implementation of the crawl command's reports
+func (c *CrawlCmd) Outputs() Outputs {
+	return Outputs{
+		Report:    c.Report,
+		Redirects: c.Redirects,
+		Sitemaps:  c.Sitemaps,
+		Analytics: c.Analytics,
+		Format:    c.Format,
+	}
+}
+
+// Outputs is the implementation of the check-links command's report
+func (c *CheckLinksCmd) Outputs() Outputs {
+	return Outputs{CheckLinks: true, Format: c.Format}
+}
+
+// Outputs is the implementation of the graph command's report
+func (c *GraphCmd) Outputs() Outputs {
+	return Outputs{Graph: c}
+}
+
+// Outputs is the implementation of the report command's report
+func (c *ReportCmd) Outputs() Outputs {
+	return Outputs{Report: c.Report, Format: c.Format, FromRecords: c.Records}
+}
+
+// Outputs is the implementation of the sitemap command's report
+func (c *SitemapCmd) Outputs() Outputs {
+	return Outputs{Sitemap: c}
+}
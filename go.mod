@@ -3,8 +3,10 @@ module tjweldon/spider
 go 1.18
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/alexflint/go-arg v1.4.3
 	golang.org/x/net v0.0.0-20220425223048-2871e0cb64e4
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require github.com/alexflint/go-scalar v1.1.0 // indirect
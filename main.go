@@ -1,111 +1,868 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/alexflint/go-arg"
+	"io"
+	"io/fs"
 	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"syscall"
+	"time"
+	"tjweldon/spider/src/fetch"
+	"tjweldon/spider/src/graph"
+	"tjweldon/spider/src/jobs"
+	"tjweldon/spider/src/links"
 	"tjweldon/spider/src/messaging"
+	"tjweldon/spider/src/records"
 	"tjweldon/spider/src/reporting"
+	"tjweldon/spider/src/robots"
+	"tjweldon/spider/src/scope"
+	"tjweldon/spider/src/sitemap"
 	"tjweldon/spider/src/swarm"
+	"tjweldon/spider/src/urls"
+	"tjweldon/spider/src/util"
 )
 
-var args struct {
-	Target  string `arg:"positional" help:"The initial url to start the swarm off at."`
-	MaxJobs int    `arg:"-l,--limit" default:"256" help:"The number of urls the swarm will visit, increase at your own risk."`
+// opts are the settings of the command being run
+var opts Options
+
+func main() {
+	outputs := ParseArgs()
+	if outputs.FromRecords != "" {
+		os.Exit(DoReport(outputs))
+	}
+	os.Exit(DoCrawl(outputs))
 }
 
-var crawlUrlPattern = regexp.MustCompile(
-	`(?m)https?://[a-zA-Z\d./:]+/[a-zA-Z\d]*(\.html)?$`,
-)
+// DoReport writes the report on the pages in a records file, returning the
+// exit status
+func DoReport(outputs Outputs) int {
+	var in io.Reader = os.Stdin
+	if outputs.FromRecords != "-" {
+		file, err := os.Open(outputs.FromRecords)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		in = file
+	}
+	out, closeOutput, err := ProvisionOutput()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeOutput()
 
-func main() {
-	arg.MustParse(&args)
-	DoCrawl()
+	bus := reporting.NewEventBus()
+	report := bus.Subscribe(ProvisionReport(outputs.Report))
+	err = records.Read(in, func(record records.Page) {
+		bus.JobQueued(record.Job())
+		bus.PageFetched(record)
+	})
+	bus.Close()
+	if err != nil {
+		log.Printf("%s: %v", outputs.FromRecords, err)
+		<-report
+		return 1
+	}
+	WriteReport(out, outputs.Format, <-report)
+	return 0
 }
 
-func DoCrawl() {
-	dispatcher, backlog := messaging.NewQ[string](swarm.SwarmSize * 1024)
-	withPreProcessors := ProvisionDispatcher(dispatcher)
+// DoCrawl runs the crawl and writes the reports, returning the exit status
+func DoCrawl(outputs Outputs) (status int) {
+	var robotsCache *robots.Cache
+	if !opts.IgnoreRobots {
+		robotsCache = robots.NewCache(opts.UserAgent)
+	}
+
+	seeds, err := opts.ReadSeeds()
+	if err != nil {
+		log.Fatal(err)
+	}
+	crawlScope := ProvisionScope(seeds)
+	out, closeOutput, err := ProvisionOutput()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeOutput()
+
+	// The context outlives the crawl, so that the reports that carry on
+	// working once it is done can still be interrupted or timed out
+	ctx, abort := context.WithCancel(context.Background())
+	defer abort()
+	if opts.Timeout > 0 {
+		ctx, abort = context.WithTimeout(ctx, opts.Timeout)
+		defer abort()
+	}
 
+	// Every reporter has to be subscribed before anything is queued
+	bus, reports := ProvisionReports(outputs)
 	var (
-		fork   messaging.Backlog[string]
-		result <-chan string
+		audit         *links.Audit
+		discrepancies *reporting.SitemapDiscrepancyReporter
+		analytics     *reporting.LinkAnalyticsReporter
 	)
+	if outputs.CheckLinks {
+		var reporter reporting.Reporter
+		audit, reporter = ProvisionLinkCheck(ctx, crawlScope, robotsCache)
+		reports = append(reports, bus.Subscribe(reporter))
+	}
+	if outputs.Redirects {
+		reports = append(reports, bus.Subscribe(reporting.NewRedirectsReporter(crawlScope.Validator())))
+	}
+	if outputs.Graph != nil {
+		settings, closeNodes, err := ProvisionGraph(outputs.Graph)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer closeNodes()
+		reports = append(reports, bus.Subscribe(reporting.NewGraphReporter(crawlScope.Validator(), settings)))
+	}
+	if outputs.Sitemap != nil {
+		settings, err := ProvisionSitemap(outputs.Sitemap, seeds)
+		if err != nil {
+			log.Fatal(err)
+		}
+		reports = append(reports, bus.Subscribe(reporting.NewSitemapReporter(crawlScope.Validator(), settings)))
+	}
+	if outputs.Sitemaps {
+		discrepancies = reporting.NewSitemapDiscrepancyReporter(crawlScope.Validator())
+		reports = append(reports, bus.Subscribe(discrepancies))
+	}
+	if outputs.Analytics {
+		canonicalise := ProvisionCanonicaliser()
+		var seedUrls []string
+		for _, seedUrl := range SeedUrls(seeds) {
+			seedUrls = append(seedUrls, canonicalise(seedUrl))
+		}
+		analytics = reporting.NewLinkAnalyticsReporter(seedUrls, crawlScope.Validator())
+		reports = append(reports, bus.Subscribe(analytics))
+	}
 
-	backlog, fork = messaging.Fork(backlog)
-	result = reporting.DomainsReport(fork)
+	dispatcher, backlog := messaging.
+		NewHostScheduler[jobs.Job](jobs.Job.Host, opts.Delay, opts.PerHost).
+		SetReadyAt(jobs.Job.ReadyAt).
+		Split()
+	if bus.Subscribers() > 0 {
+		dispatcher = messaging.WithTap(dispatcher, bus.JobQueued)
+	}
+	seen := ProvisionSeenSet()
+	if closer, ok := seen.(interface{ Close() error }); ok {
+		defer closer.Close()
+	}
+
+	// Once the frontier is open, failures return so that it is saved
+	if opts.State != "" {
+		frontier := ProvisionFrontier()
+		if opts.Resumed && bus.Subscribers() > 0 {
+			if err := ReplayRecords(bus, frontier); err != nil {
+				log.Printf("Replaying the pages already crawled: %v", err)
+				return 1
+			}
+		}
+		dispatcher, backlog = frontier.Split(dispatcher, backlog)
+		stopCheckpoints := Checkpoint(frontier, seen)
+		defer func() {
+			stopCheckpoints()
+			if err := frontier.Save(); err != nil {
+				log.Printf("Saving the crawl state: %v", err)
+			}
+			log.Printf("Saved %d unfinished jobs to %s", frontier.Pending(), opts.State)
+		}()
+	}
+	provisioned := ProvisionDispatcher(dispatcher, seen, crawlScope, robotsCache)
+
+	spawner := NewSpawner(provisioned, dispatcher, ProvisionFetcher(), robotsCache)
+	closers := []util.Closer{provisioned}
+	awaitRecords := func() {}
+	if opts.Records != "" || opts.State != "" {
+		var closer util.Closer
+		if closer, awaitRecords, err = ProvisionRecords(spawner, bus); err != nil {
+			log.Printf("Opening the records: %v", err)
+			return 1
+		}
+		closers = append(closers, closer)
+	} else if bus.Subscribers() > 0 {
+		spawner.SetRecords(messaging.DispatchFunc[records.Page](func(page records.Page) bool {
+			bus.PageFetched(page)
+			return true
+		}))
+	}
+	if bus.Subscribers() > 0 {
+		ProvisionLinks(spawner, bus)
+	}
+	closers = append(closers, bus)
 
 	s := swarm.
-		NewSwarm(NewSpawner(withPreProcessors).Create).
+		NewSwarm(spawner.Create, opts.Workers).
+		SetScaling(ProvisionScaling()).
 		SetIncoming(backlog).
-		SetDispatcher(withPreProcessors, args.Target)
-	defer CleanUp(result, withPreProcessors)
+		SetDispatcher(provisioned, SeedUrls(seeds)...)
+	defer func() {
+		CleanUp(out, outputs.Format, reports, closers...)
+		awaitRecords()
+		if audit != nil && len(audit.Broken()) > 0 {
+			status = 1
+		}
+	}()
+	HandleSignals(s, abort)
+
+	if outputs.Sitemaps {
+		listed := ReadSitemaps(ctx, provisioned, seeds, robotsCache)
+		discrepancies.SetListed(listed)
+		if analytics != nil {
+			analytics.SetListed(listed)
+		}
+	}
+
+	s.Spawn(ctx)
+	return 0
+}
+
+// HandleSignals stops the swarm gracefully on the first SIGINT or SIGTERM,
+// letting the crawls in progress finish so that the report is complete. A
+// second signal aborts them. If no crawls are in progress, such as while the
+// links are being checked, the first signal aborts straight away.
+func HandleSignals(s *swarm.Swarm, abort context.CancelFunc) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 
-	s.Spawn()
+	go func() {
+		<-signals
+		if s.Size() > 0 {
+			log.Println("Stopping, waiting for crawls in progress. Interrupt again to abort them.")
+			s.Stop()
+			<-signals
+		}
+		log.Println("Aborting.")
+		abort()
+	}()
 }
 
-func ProvisionDispatcher(dispatcher messaging.Dispatcher[string]) messaging.Dispatcher[string] {
-	withDeDuplication := messaging.WithDeDuplication[string](dispatcher).
-		SetMaxJobs(256)
+func ProvisionDispatcher(
+	dispatcher messaging.Dispatcher[jobs.Job],
+	seen messaging.SeenSet[string],
+	crawlScope *scope.Scope,
+	robotsCache *robots.Cache,
+) messaging.Dispatcher[jobs.Job] {
+	withDeDuplication := messaging.WithDeDuplicationBy[jobs.Job, string](dispatcher, jobs.Job.Key).
+		SetSeenSet(seen).
+		SetMaxJobs(opts.MaxJobs)
 
-	withValidation := AddValidation(withDeDuplication)
+	withValidation := AddValidation(withDeDuplication, crawlScope, robotsCache)
 	withPreProcessors := AddPreProcessors(withValidation)
 	return withPreProcessors
 }
 
-func CleanUp(result <-chan string, d messaging.Dispatcher[string]) {
-	d.Close()
-	fmt.Println(<-result)
+// ProvisionLinks sets the crawlers publishing the links they find to the bus
+func ProvisionLinks(spawner *Spawner, bus *reporting.EventBus) {
+	linkDispatcher := messaging.WithPreProcessing[links.Link](
+		messaging.WithValidation[links.Link](
+			messaging.DispatchFunc[links.Link](func(link links.Link) bool {
+				bus.LinkFound(link)
+				return true
+			}),
+			links.ValidateTarget(scope.Schemes("http", "https")),
+		),
+		ProvisionUrlPreProcessors(links.PreProcessTarget)...,
+	)
+	spawner.SetLinks(linkDispatcher)
 }
 
-func AddPreProcessors(dispatcher messaging.Dispatcher[string]) messaging.Dispatcher[string] {
-	dispatcher = messaging.WithPreProcessing[string](
-		dispatcher,
-		func(item string) string {
-			return strings.TrimLeft(item, "./")
-		},
-		func(item string) string {
-			if strings.HasPrefix(item, "http") {
-				return item
+// ProvisionLinkCheck returns the reporter that audits the links found along
+// with the pages crawled, and checks the links that weren't crawled once the
+// crawl is over, until the context is cancelled. robots.txt is only honoured
+// for links within the crawl scope, since a single check of an external link
+// is no burden and its robots.txt may be what's broken.
+func ProvisionLinkCheck(
+	ctx context.Context,
+	crawlScope *scope.Scope,
+	robotsCache *robots.Cache,
+) (*links.Audit, reporting.Reporter) {
+	checker := links.NewChecker(ProvisionFetcher()).SetWorkers(opts.Workers)
+	if robotsCache != nil {
+		checker.SetThrottle(robotsCache).SetAllowed(func(target string) bool {
+			return !crawlScope.Contains(target) || robotsCache.Allowed(target)
+		})
+	}
+
+	audit := links.NewAudit()
+	return audit, reporting.NewBrokenLinksReporter(ctx, audit, checker)
+}
+
+// ReadSitemaps dispatches the pages listed in the sitemaps of the seeds'
+// hosts, returning their canonical urls.
+func ReadSitemaps(
+	ctx context.Context,
+	dispatcher messaging.Dispatcher[jobs.Job],
+	seeds []scope.Seed,
+	robotsCache *robots.Cache,
+) []string {
+	// Sitemaps may be bigger than --max-body allows pages to be, up to the
+	// protocol's limit
+	reader := sitemap.NewReader(ProvisionHttpFetcher().SetMaxBodySize(sitemap.MaxSize))
+	if robotsCache != nil {
+		reader.SetRobots(robotsCache)
+	}
+	canonicalise := ProvisionCanonicaliser()
+
+	var listed []string
+	reader.Read(ctx, reader.Locate(SeedUrls(seeds)...), func(page sitemap.Entry, sitemapUrl string) {
+		listed = append(listed, canonicalise(page.Loc))
+		dispatcher.Dispatch(jobs.FromSitemap(page.Loc, sitemapUrl))
+	})
+	log.Printf("Read %d pages from sitemaps", len(listed))
+	return listed
+}
+
+func ProvisionFetcher() fetch.Fetcher {
+	return ProvisionHttpFetcher()
+}
+
+// ProvisionHttpFetcher returns an HttpFetcher with the fetching options, for
+// the callers that need to change some of them
+func ProvisionHttpFetcher() *fetch.HttpFetcher {
+	return fetch.NewHttpFetcher().
+		SetUserAgent(opts.UserAgent).
+		SetTimeout(opts.FetchTimeout).
+		SetMaxBodySize(opts.MaxBody).
+		SetMaxRedirects(opts.MaxRedirects)
+}
+
+func ProvisionRetryPolicy() fetch.RetryPolicy {
+	return fetch.RetryPolicy{
+		MaxAttempts: opts.MaxAttempts,
+		BaseDelay:   opts.RetryDelay,
+		MaxDelay:    opts.MaxRetry,
+	}
+}
+
+func ProvisionScaling() swarm.Scaling {
+	if opts.MaxWorkers <= opts.Workers {
+		return swarm.Scaling{}
+	}
+	return swarm.Scaling{
+		Min:        opts.MinWorkers,
+		Max:        opts.MaxWorkers,
+		Interval:   2 * time.Second,
+		MaxLatency: opts.MaxLatency,
+	}
+}
+
+func ProvisionScope(seeds []scope.Seed) *scope.Scope {
+	crawlScope, err := scope.ForSeeds(seeds...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return crawlScope.
+		AllowHosts(opts.AllowHosts...).
+		DenyHosts(opts.DenyHosts...).
+		Include(MustCompileAll(opts.Include)...).
+		Exclude(MustCompileAll(opts.Exclude)...)
+}
+
+// SeedUrls returns the urls of the seeds, to start the swarm off with
+func SeedUrls(seeds []scope.Seed) []string {
+	seedUrls := make([]string, 0, len(seeds))
+	for _, seed := range seeds {
+		seedUrls = append(seedUrls, seed.Url)
+	}
+	return seedUrls
+}
+
+// MustCompileAll compiles the regular expressions passed on the command line,
+// exiting if any of them are invalid.
+func MustCompileAll(exprs []string) []*regexp.Regexp {
+	patterns := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+func ProvisionSeenSet() messaging.SeenSet[string] {
+	if opts.State != "" {
+		seen, err := messaging.OpenDiskSet(filepath.Join(opts.State, "seen"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		return seen
+	}
+
+	switch opts.Seen {
+	case "hash":
+		return messaging.NewHashSet[string]()
+	case "bloom":
+		expected := opts.MaxJobs
+		if expected <= 0 {
+			expected = 1000000
+		}
+		return messaging.NewBloomFilter(expected, opts.FalsePos)
+	case "disk":
+		seen, err := messaging.OpenDiskSet(opts.SeenFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return seen
+	default:
+		log.Fatalf("unknown --seen %q, expected hash, bloom or disk", opts.Seen)
+		return nil
+	}
+}
+
+// ProvisionGraph returns the settings for exporting the link graph, along
+// with a function to close the --nodes file with.
+func ProvisionGraph(cmd *GraphCmd) (reporting.GraphSettings, func(), error) {
+	known := false
+	for _, format := range graph.Formats {
+		known = known || cmd.GraphFormat == format
+	}
+	if !known {
+		return reporting.GraphSettings{}, nil, fmt.Errorf("unknown --graph-format %q, expected graphml, dot or csv", cmd.GraphFormat)
+	}
+
+	settings := reporting.GraphSettings{Format: cmd.GraphFormat, ByHost: cmd.ByHost}
+	if cmd.Nodes == "" || cmd.GraphFormat != "csv" {
+		return settings, func() {}, nil
+	}
+	nodes, closeNodes, err := OpenOutput(cmd.Nodes)
+	settings.Nodes = nodes
+	return settings, closeNodes, err
+}
+
+// ProvisionFrontier opens the journal of the jobs that are queued or in
+// progress in the --state directory, which has the jobs to carry on with if
+// the crawl is being resumed.
+func ProvisionFrontier() *messaging.Frontier[jobs.Job, string] {
+	frontier, err := messaging.OpenFrontier[jobs.Job, string](
+		filepath.Join(opts.State, "frontier.jsonl"), jobs.Job.Key,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if pending := frontier.Pending(); pending > 0 {
+		log.Printf("Resuming %d unfinished jobs from %s", pending, opts.State)
+	}
+	return frontier
+}
+
+// Checkpoint flushes the crawl state to disk every --checkpoint, until the
+// returned function is called. With no --checkpoint, the state is only
+// saved once the crawl stops.
+func Checkpoint(frontier *messaging.Frontier[jobs.Job, string], seen messaging.SeenSet[string]) func() {
+	if opts.Checkpoint <= 0 {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(opts.Checkpoint)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
 			}
-			target := strings.TrimRight(args.Target, "/")
-			altTarget := strings.Join(strings.Split(target, "www."), "")
-			if !(strings.HasPrefix(item, target) || strings.HasPrefix(item, altTarget)) {
-				return strings.Join([]string{target, item}, "/")
+			if err := frontier.Checkpoint(); err != nil {
+				log.Printf("Checkpointing the crawl state: %v", err)
 			}
-			return item
+			if syncer, ok := seen.(interface{ Sync() error }); ok {
+				if err := syncer.Sync(); err != nil {
+					log.Printf("Checkpointing the crawl state: %v", err)
+				}
+			}
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// ProvisionSitemap returns the settings for writing a sitemap. If it needs
+// splitting, the parts are written to the same directory as the output.
+func ProvisionSitemap(cmd *SitemapCmd, seeds []scope.Seed) (reporting.SitemapSettings, error) {
+	location := cmd.SitemapUrl
+	if location == "" {
+		root, err := url.Parse(seeds[0].Url)
+		if err != nil {
+			return reporting.SitemapSettings{}, err
+		}
+		location = (&url.URL{Scheme: root.Scheme, Host: root.Host, Path: "/"}).String()
+	}
+	if !strings.HasSuffix(location, "/") {
+		location += "/"
+	}
+
+	dir := "."
+	if opts.Output != "-" {
+		dir = filepath.Dir(opts.Output)
+	}
+	return reporting.SitemapSettings{
+		LastMod:  cmd.LastMod,
+		MaxUrls:  cmd.MaxUrls,
+		Location: location,
+		Write: func(name string, document []byte) error {
+			path := filepath.Join(dir, name)
+			log.Printf("Writing %s", path)
+			return os.WriteFile(path, document, 0644)
 		},
+		Canonicalise: ProvisionCanonicaliser(),
+	}, nil
+}
+
+// ProvisionReports subscribes the reporters asked for to an event bus,
+// returning it along with the channels their reports are sent on.
+func ProvisionReports(outputs Outputs) (*reporting.EventBus, []<-chan string) {
+	bus := reporting.NewEventBus()
+	var reports []<-chan string
+	if outputs.Report != "" {
+		reports = append(reports, bus.Subscribe(ProvisionReport(outputs.Report)))
+	}
+	return bus, reports
+}
+
+func ProvisionReport(name string) reporting.Reporter {
+	switch name {
+	case "domains":
+		return reporting.NewDomainsReporter()
+	case "depths":
+		return reporting.NewDepthsReporter()
+	default:
+		log.Fatalf("unknown --report %q, expected domains or depths", name)
+		return nil
+	}
+}
+
+// ProvisionOutput opens the file the reports are written to, returning a
+// function to close it with.
+func ProvisionOutput() (io.Writer, func(), error) {
+	return OpenOutput(opts.Output)
+}
+
+// ProvisionRecords sets the crawlers recording each page they fetch, which
+// are written to the --records file and the --state directory as they come
+// in and published to the bus. It returns the dispatcher to close once the
+// crawl is done, and a function that waits for the records to be written and
+// closes the files.
+func ProvisionRecords(spawner *Spawner, bus *reporting.EventBus) (util.Closer, func(), error) {
+	var paths []string
+	if opts.Records != "" {
+		paths = append(paths, opts.Records)
+	}
+	if opts.State != "" {
+		paths = append(paths, filepath.Join(opts.State, stateRecordsFile))
+	}
+	var (
+		outs      []io.Writer
+		closeOuts []func()
 	)
-	return dispatcher
+	closeAll := func() {
+		for _, closeOut := range closeOuts {
+			closeOut()
+		}
+	}
+	for _, path := range paths {
+		out, closeOut, err := OpenRecords(path)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		outs = append(outs, out)
+		closeOuts = append(closeOuts, closeOut)
+	}
+
+	recordQueue, recordBacklog := messaging.NewQ[records.Page](1024)
+	if bus.Subscribers() > 0 {
+		spawner.SetRecords(messaging.WithTap[records.Page](recordQueue, bus.PageFetched))
+	} else {
+		spawner.SetRecords(recordQueue)
+	}
+
+	written := records.Write(io.MultiWriter(outs...), recordBacklog)
+	return recordQueue, func() {
+		if err := <-written; err != nil {
+			log.Printf("Writing records: %v", err)
+		}
+		closeAll()
+	}, nil
+}
+
+// ReplayRecords publishes the pages recorded in the --state directory before
+// a resumed crawl stopped, so that its report covers the whole crawl. Pages
+// that are still in the frontier are left out, since they will be crawled
+// again, as are the repeats of a page that was crawled again by an earlier
+// resume.
+func ReplayRecords(bus *reporting.EventBus, frontier *messaging.Frontier[jobs.Job, string]) error {
+	path := filepath.Join(opts.State, stateRecordsFile)
+	if err := TrimPartialRecord(path); err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	replayed := map[string]bool{}
+	return records.Read(file, func(record records.Page) {
+		job := record.Job()
+		if replayed[job.Key()] || frontier.Holds(job.Key()) {
+			return
+		}
+		replayed[job.Key()] = true
+		bus.JobQueued(job)
+		bus.PageFetched(record)
+	})
+}
+
+// OpenOutput creates the file at path, or returns stdout for "-", along with
+// a function to close it with.
+func OpenOutput(path string) (io.Writer, func(), error) {
+	if path == "-" {
+		return os.Stdout, func() {}, nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, func() {
+		if err := file.Close(); err != nil {
+			log.Printf("Closing %s: %v", path, err)
+		}
+	}, nil
+}
+
+// OpenRecords opens a file for records like OpenOutput, except that a resumed
+// crawl appends to it, so that the records written before the crawl stopped
+// are kept.
+func OpenRecords(path string) (io.Writer, func(), error) {
+	if path == "-" || !opts.Resumed {
+		return OpenOutput(path)
+	}
+	if err := TrimPartialRecord(path); err != nil {
+		return nil, nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o666)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, func() {
+		if err := file.Close(); err != nil {
+			log.Printf("Closing %s: %v", path, err)
+		}
+	}, nil
 }
 
-func AddValidation(dispatcher messaging.Dispatcher[string]) messaging.Dispatcher[string] {
-	dispatcher = messaging.WithValidation[string](
+// TrimPartialRecord cuts off the last line of a records file if it has no
+// newline, which is what a crawl leaves behind if it is killed while writing
+// a record. A file that doesn't exist is left alone.
+func TrimPartialRecord(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	// Look back from the end a chunk at a time for the last newline
+	chunk := make([]byte, 4096)
+	for end := info.Size(); end > 0; {
+		start := end - int64(len(chunk))
+		if start < 0 {
+			start = 0
+		}
+		read := chunk[:end-start]
+		if _, err := file.ReadAt(read, start); err != nil {
+			return err
+		}
+		if end == info.Size() && read[len(read)-1] == '\n' {
+			return nil
+		}
+		if i := bytes.LastIndexByte(read, '\n'); i >= 0 {
+			return file.Truncate(start + int64(i) + 1)
+		}
+		end = start
+	}
+	return file.Truncate(0)
+}
+
+// CleanUp closes the dispatchers that the reports are fed from, then writes
+// each report once it is complete.
+func CleanUp(out io.Writer, format string, reports []<-chan string, closers ...util.Closer) {
+	for _, closer := range closers {
+		closer.Close()
+	}
+	for _, report := range reports {
+		WriteReport(out, format, <-report)
+	}
+}
+
+// WriteReport writes a report in the format asked for. Reports that aren't
+// JSON, such as sitemaps, are written as they are.
+func WriteReport(out io.Writer, format, report string) {
+	if format == "pretty" {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, []byte(report), "", "  "); err == nil {
+			report = indented.String()
+		}
+	}
+	if _, err := fmt.Fprintln(out, report); err != nil {
+		log.Printf("Writing report: %v", err)
+	}
+}
+
+// ProvisionUrlPreProcessors returns the rewrite rules followed by the
+// canonicaliser, adapted by adapt to whatever carries the urls.
+func ProvisionUrlPreProcessors[T any](
+	adapt func(messaging.PreProcessor[string]) messaging.PreProcessor[T],
+) []messaging.PreProcessor[T] {
+	var preProcessors []messaging.PreProcessor[T]
+	for _, rule := range opts.Rewrites {
+		pattern := regexp.MustCompile(rule.Match)
+		preProcessors = append(preProcessors, adapt(urls.Rewrite(pattern, rule.Replace)))
+	}
+
+	canonicaliser := urls.NewCanonicaliser().
+		AddTrackingParams(opts.StripParams...).
+		SetFoldTrailingSlash(opts.FoldSlashes)
+	return append(preProcessors, adapt(canonicaliser.PreProcessor()))
+}
+
+// ProvisionCanonicaliser returns a PreProcessor that applies the rewrite
+// rules and canonicaliser to a url, the way jobs' urls are.
+func ProvisionCanonicaliser() messaging.PreProcessor[string] {
+	preProcessors := ProvisionUrlPreProcessors(func(p messaging.PreProcessor[string]) messaging.PreProcessor[string] {
+		return p
+	})
+	return func(url string) string {
+		for _, preProcess := range preProcessors {
+			url = preProcess(url)
+		}
+		return url
+	}
+}
+
+func AddPreProcessors(dispatcher messaging.Dispatcher[jobs.Job]) messaging.Dispatcher[jobs.Job] {
+	dispatcher = messaging.WithPreProcessing[jobs.Job](
 		dispatcher,
-		func(item string) bool {
-			return !strings.Contains(item, "#")
-		},
-		func(item string) bool {
-			return crawlUrlPattern.MatchString(item)
-		},
+		ProvisionUrlPreProcessors(jobs.PreProcessUrl)...,
 	)
 	return dispatcher
 }
 
+func AddValidation(
+	dispatcher messaging.Dispatcher[jobs.Job],
+	crawlScope *scope.Scope,
+	robotsCache *robots.Cache,
+) messaging.Dispatcher[jobs.Job] {
+	validators := []messaging.Validator[jobs.Job]{
+		jobs.ValidateUrl(scope.Schemes("http", "https")),
+		jobs.ValidateUrl(crawlScope.Validator()),
+	}
+	if opts.MaxDepth >= 0 {
+		validators = append(validators, jobs.MaxDepth(opts.MaxDepth))
+	}
+	// robots.txt goes last so that it is only fetched for urls we would
+	// otherwise crawl
+	if robotsCache != nil {
+		validators = append(validators, jobs.ValidateUrl(robotsCache.Validator()))
+	}
+
+	dispatcher = messaging.WithValidation[jobs.Job](dispatcher, validators...)
+	return dispatcher
+}
+
 type Spawner struct {
-	dispatcher messaging.Dispatcher[string]
+	dispatcher  messaging.Dispatcher[jobs.Job]
+	requeue     messaging.Dispatcher[jobs.Job]
+	links       messaging.Dispatcher[links.Link]
+	records     messaging.Dispatcher[records.Page]
+	fetcher     fetch.Fetcher
+	robotsCache *robots.Cache
 }
 
-func NewSpawner(dispatcher messaging.Dispatcher[string]) *Spawner {
-	return &Spawner{dispatcher: dispatcher}
+// NewSpawner returns a Spawner for crawlers that send the links they find to
+// dispatcher and failed jobs straight back to the queue with requeue.
+func NewSpawner(
+	dispatcher, requeue messaging.Dispatcher[jobs.Job],
+	fetcher fetch.Fetcher,
+	robotsCache *robots.Cache,
+) *Spawner {
+	return &Spawner{
+		dispatcher:  dispatcher,
+		requeue:     requeue,
+		fetcher:     fetcher,
+		robotsCache: robotsCache,
+	}
+}
+
+// SetLinks is a fluent setter for the dispatcher the crawlers record each
+// link they find on
+func (s *Spawner) SetLinks(recorder messaging.Dispatcher[links.Link]) *Spawner {
+	s.links = recorder
+	return s
+}
+
+// SetRecords is a fluent setter for the dispatcher the crawlers send a
+// record of each page to
+func (s *Spawner) SetRecords(records messaging.Dispatcher[records.Page]) *Spawner {
+	s.records = records
+	return s
 }
 
 func (s *Spawner) Create() *swarm.Crawler {
 	log.Println("Spawning Crawler")
 	HasLinks := swarm.HasAttrs("src", "href")
-	return swarm.NewCrawler().
-		AddScraper(swarm.RecoverUrls(s.dispatcher), HasLinks)
-	// .AddScraper(swarm.DumpHtml, HasLinks.And(swarm.IsLeafNode))
+	crawler := swarm.NewCrawler().
+		SetFetcher(s.fetcher).
+		SetRetry(ProvisionRetryPolicy(), s.requeue).
+		SetHeadFirst(opts.HeadFirst)
+	if s.robotsCache != nil {
+		crawler.SetThrottle(s.robotsCache)
+	}
+	if s.records != nil {
+		crawler.SetRecords(s.records)
+	}
+	if claimer, ok := s.dispatcher.(messaging.Claimer[jobs.Job]); ok {
+		crawler.SetClaimer(claimer).SetCanonicaliser(ProvisionCanonicaliser())
+	}
+
+	if opts.ScraperEnabled("css") {
+		css := swarm.RecoverCssUrls(s.dispatcher)
+		if s.links != nil {
+			css = css.Then(swarm.RecordCssLinks(s.links))
+		}
+		crawler.AddContentHandler("text/css", css)
+	}
+	if opts.ScraperEnabled("links") {
+		if s.links != nil {
+			crawler.AddScraper(swarm.RecordLinks(s.links), HasLinks)
+		}
+		crawler.AddScraper(swarm.RecoverUrls(s.dispatcher), HasLinks)
+	}
+	if opts.ScraperEnabled("dump-html") {
+		crawler.AddScraper(swarm.DumpHtml, HasLinks.And(swarm.IsLeafNode))
+	}
+	return crawler
 }
@@ -0,0 +1,400 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"tjweldon/spider/src/graph"
+	"tjweldon/spider/src/scope"
+	"tjweldon/spider/src/sitemap"
+	"tjweldon/spider/src/util"
+)
+
+// Config is a crawl described in a YAML or TOML file. Every setting is
+// optional, and those that are left out keep their command line defaults.
+type Config struct {
+	// Seeds are urls, each optionally followed by its own scope mode
+	Seeds      []string   `yaml:"seeds" toml:"seeds"`
+	Scope      Scope      `yaml:"scope" toml:"scope"`
+	Filters    Filters    `yaml:"filters" toml:"filters"`
+	Rewrite    Rewrite    `yaml:"rewrite" toml:"rewrite"`
+	Scrapers   []string   `yaml:"scrapers" toml:"scrapers"`
+	RateLimits RateLimits `yaml:"rate_limits" toml:"rate_limits"`
+	Fetch      Fetch      `yaml:"fetch" toml:"fetch"`
+	Dedup      Dedup      `yaml:"dedup" toml:"dedup"`
+	Output     Output     `yaml:"output" toml:"output"`
+	State      State      `yaml:"state" toml:"state"`
+}
+
+// Scope is how far from the seeds the crawl may go
+type Scope struct {
+	Mode       string   `yaml:"mode" toml:"mode"`
+	AllowHosts []string `yaml:"allow_hosts" toml:"allow_hosts"`
+	DenyHosts  []string `yaml:"deny_hosts" toml:"deny_hosts"`
+}
+
+// Filters narrow down which urls within the scope are crawled
+type Filters struct {
+	Include  []string `yaml:"include" toml:"include"`
+	Exclude  []string `yaml:"exclude" toml:"exclude"`
+	MaxDepth *int     `yaml:"max_depth" toml:"max_depth"`
+	Limit    *int     `yaml:"limit" toml:"limit"`
+}
+
+// Rewrite is how urls are changed before they are deduplicated
+type Rewrite struct {
+	StripParams []string `yaml:"strip_params" toml:"strip_params"`
+	FoldSlashes *bool    `yaml:"fold_slashes" toml:"fold_slashes"`
+	Rules       []Rule   `yaml:"rules" toml:"rules"`
+}
+
+// Rule replaces the matches of a regular expression in each url
+type Rule struct {
+	Match   string `yaml:"match" toml:"match"`
+	Replace string `yaml:"replace" toml:"replace"`
+}
+
+// RateLimits are how hard the crawl may work, overall and per host
+type RateLimits struct {
+	Delay      string `yaml:"delay" toml:"delay"`
+	PerHost    *int   `yaml:"per_host" toml:"per_host"`
+	Workers    *int   `yaml:"workers" toml:"workers"`
+	MinWorkers *int   `yaml:"min_workers" toml:"min_workers"`
+	MaxWorkers *int   `yaml:"max_workers" toml:"max_workers"`
+	MaxLatency string `yaml:"max_latency" toml:"max_latency"`
+}
+
+// Fetch is how each page is retrieved
+type Fetch struct {
+	UserAgent     string `yaml:"user_agent" toml:"user_agent"`
+	IgnoreRobots  *bool  `yaml:"ignore_robots" toml:"ignore_robots"`
+	Timeout       string `yaml:"timeout" toml:"timeout"`
+	FetchTimeout  string `yaml:"fetch_timeout" toml:"fetch_timeout"`
+	MaxBody       *int64 `yaml:"max_body" toml:"max_body"`
+	MaxAttempts   *int   `yaml:"max_attempts" toml:"max_attempts"`
+	RetryDelay    string `yaml:"retry_delay" toml:"retry_delay"`
+	MaxRetryDelay string `yaml:"max_retry_delay" toml:"max_retry_delay"`
+	MaxRedirects  *int   `yaml:"max_redirects" toml:"max_redirects"`
+	HeadFirst     *bool  `yaml:"head_first" toml:"head_first"`
+}
+
+// Dedup is how the urls already visited are remembered
+type Dedup struct {
+	Seen           string   `yaml:"seen" toml:"seen"`
+	SeenFile       string   `yaml:"seen_file" toml:"seen_file"`
+	FalsePositives *float64 `yaml:"false_positives" toml:"false_positives"`
+}
+
+// State is where the crawl is saved so that it can be resumed
+type State struct {
+	Dir        string `yaml:"dir" toml:"dir"`
+	Checkpoint string `yaml:"checkpoint" toml:"checkpoint"`
+}
+
+// Output is where the reports go and what they contain
+type Output struct {
+	File      string `yaml:"file" toml:"file"`
+	Records   string `yaml:"records" toml:"records"`
+	Format    string `yaml:"format" toml:"format"`
+	Report    string `yaml:"report" toml:"report"`
+	Redirects *bool  `yaml:"redirects" toml:"redirects"`
+	Sitemaps  *bool  `yaml:"sitemaps" toml:"sitemaps"`
+	Analytics *bool  `yaml:"analytics" toml:"analytics"`
+
+	// Sitemap settings
+	LastMod    *bool  `yaml:"lastmod" toml:"lastmod"`
+	MaxUrls    *int   `yaml:"max_urls" toml:"max_urls"`
+	SitemapUrl string `yaml:"sitemap_url" toml:"sitemap_url"`
+
+	// Graph settings
+	GraphFormat string `yaml:"graph_format" toml:"graph_format"`
+	ByHost      *bool  `yaml:"by_host" toml:"by_host"`
+	Nodes       string `yaml:"nodes" toml:"nodes"`
+}
+
+// Scrapers are the names of the scrapers that can be enabled
+var Scrapers = []string{"links", "css", "dump-html"}
+
+// KeyError is a problem with the value of one setting in a config file
+type KeyError struct {
+	Key string
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Key, e.Err)
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// Load reads and validates a config file. The format is chosen by the
+// extension: .toml for TOML, and YAML otherwise. Unknown keys are an error,
+// so that typos don't go unnoticed.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = decodeToml(data, config)
+	} else {
+		err = decodeYaml(data, config)
+	}
+	if err == nil {
+		err = config.Validate()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// decodeYaml decodes YAML, rejecting unknown keys
+func decodeYaml(data []byte, config *Config) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// decodeToml decodes TOML, rejecting unknown keys
+func decodeToml(data []byte, config *Config) error {
+	meta, err := toml.Decode(string(data), config)
+	if err != nil {
+		return err
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		return &KeyError{Key: undecoded[0].String(), Err: fmt.Errorf("unknown key")}
+	}
+	return nil
+}
+
+// Validate checks the values that the file format can't, returning a
+// KeyError for the first one that is wrong.
+func (c *Config) Validate() error {
+	for i, seed := range c.Seeds {
+		if _, err := scope.ParseSeed(seed, scope.Domain); err != nil {
+			return keyError(index("seeds", i), err)
+		}
+	}
+
+	if c.Scope.Mode != "" {
+		if _, err := scope.ParseMode(c.Scope.Mode); err != nil {
+			return keyError("scope.mode", err)
+		}
+	}
+
+	for i, expr := range c.Filters.Include {
+		if _, err := regexp.Compile(expr); err != nil {
+			return keyError(index("filters.include", i), err)
+		}
+	}
+	for i, expr := range c.Filters.Exclude {
+		if _, err := regexp.Compile(expr); err != nil {
+			return keyError(index("filters.exclude", i), err)
+		}
+	}
+	for i, rule := range c.Rewrite.Rules {
+		if rule.Match == "" {
+			return keyError(index("rewrite.rules", i)+".match", fmt.Errorf("is required"))
+		}
+		if _, err := regexp.Compile(rule.Match); err != nil {
+			return keyError(index("rewrite.rules", i)+".match", err)
+		}
+	}
+
+	for i, name := range c.Scrapers {
+		if !util.Contains(Scrapers, name) {
+			return keyError(index("scrapers", i), fmt.Errorf(
+				"unknown scraper %q, expected one of %s", name, strings.Join(Scrapers, ", "),
+			))
+		}
+	}
+
+	durations := map[string]string{
+		"rate_limits.delay":       c.RateLimits.Delay,
+		"rate_limits.max_latency": c.RateLimits.MaxLatency,
+		"fetch.timeout":           c.Fetch.Timeout,
+		"fetch.fetch_timeout":     c.Fetch.FetchTimeout,
+		"fetch.retry_delay":       c.Fetch.RetryDelay,
+		"fetch.max_retry_delay":   c.Fetch.MaxRetryDelay,
+		"state.checkpoint":        c.State.Checkpoint,
+	}
+	for _, key := range sortedKeys(durations) {
+		if value := durations[key]; value != "" {
+			if _, err := time.ParseDuration(value); err != nil {
+				return keyError(key, err)
+			}
+		}
+	}
+
+	choices := []struct {
+		key, value string
+		allowed    []string
+	}{
+		{"dedup.seen", c.Dedup.Seen, []string{"hash", "bloom", "disk"}},
+		{"output.format", c.Output.Format, []string{"json", "pretty"}},
+		{"output.report", c.Output.Report, []string{"domains", "depths"}},
+		{"output.graph_format", c.Output.GraphFormat, graph.Formats},
+	}
+	for _, choice := range choices {
+		if choice.value != "" && !util.Contains(choice.allowed, choice.value) {
+			return keyError(choice.key, fmt.Errorf(
+				"unknown value %q, expected one of %s", choice.value, strings.Join(choice.allowed, ", "),
+			))
+		}
+	}
+
+	if c.Dedup.FalsePositives != nil && (*c.Dedup.FalsePositives <= 0 || *c.Dedup.FalsePositives >= 1) {
+		return keyError("dedup.false_positives", fmt.Errorf("must be between 0 and 1"))
+	}
+	if c.Output.MaxUrls != nil && (*c.Output.MaxUrls < 1 || *c.Output.MaxUrls > sitemap.MaxUrls) {
+		return keyError("output.max_urls", fmt.Errorf("must be between 1 and %d", sitemap.MaxUrls))
+	}
+	return nil
+}
+
+// Args returns the command line flags equivalent to the settings in the
+// file that the command accepts. Putting these ahead of argv, the flags that
+// were actually given, means that the latter win. Lists are added to by each
+// of their flags rather than replaced, so a list in the file is left out if
+// its flag is in argv.
+func (c *Config) Args(command string, argv []string) []string {
+	var args []string
+	str := func(flag, value string) {
+		if value != "" {
+			args = append(args, flag+"="+value)
+		}
+	}
+	list := func(flag string, values []string) {
+		if given(argv, flag) {
+			return
+		}
+		for _, value := range values {
+			args = append(args, flag+"="+value)
+		}
+	}
+	num := func(flag string, value *int) {
+		if value != nil {
+			args = append(args, flag+"="+strconv.Itoa(*value))
+		}
+	}
+	boolean := func(flag string, value *bool) {
+		if value != nil {
+			args = append(args, flag+"="+strconv.FormatBool(*value))
+		}
+	}
+
+	str("--scope", c.Scope.Mode)
+	list("--allow-host", c.Scope.AllowHosts)
+	list("--deny-host", c.Scope.DenyHosts)
+
+	list("--include", c.Filters.Include)
+	list("--exclude", c.Filters.Exclude)
+	num("--max-depth", c.Filters.MaxDepth)
+	num("--limit", c.Filters.Limit)
+
+	list("--strip-param", c.Rewrite.StripParams)
+	boolean("--fold-slashes", c.Rewrite.FoldSlashes)
+
+	str("--delay", c.RateLimits.Delay)
+	num("--per-host", c.RateLimits.PerHost)
+	num("--workers", c.RateLimits.Workers)
+	num("--min-workers", c.RateLimits.MinWorkers)
+	num("--max-workers", c.RateLimits.MaxWorkers)
+	str("--max-latency", c.RateLimits.MaxLatency)
+
+	str("--user-agent", c.Fetch.UserAgent)
+	boolean("--ignore-robots", c.Fetch.IgnoreRobots)
+	str("--timeout", c.Fetch.Timeout)
+	str("--fetch-timeout", c.Fetch.FetchTimeout)
+	if c.Fetch.MaxBody != nil {
+		args = append(args, "--max-body="+strconv.FormatInt(*c.Fetch.MaxBody, 10))
+	}
+	num("--max-attempts", c.Fetch.MaxAttempts)
+	str("--retry-delay", c.Fetch.RetryDelay)
+	str("--max-retry-delay", c.Fetch.MaxRetryDelay)
+	num("--max-redirects", c.Fetch.MaxRedirects)
+	boolean("--head-first", c.Fetch.HeadFirst)
+
+	str("--seen", c.Dedup.Seen)
+	str("--seen-file", c.Dedup.SeenFile)
+	if c.Dedup.FalsePositives != nil {
+		args = append(args, "--false-positives="+strconv.FormatFloat(*c.Dedup.FalsePositives, 'g', -1, 64))
+	}
+
+	str("--state", c.State.Dir)
+	str("--checkpoint", c.State.Checkpoint)
+
+	str("--output", c.Output.File)
+	str("--records", c.Output.Records)
+	if command == "crawl" || command == "check-links" {
+		str("--format", c.Output.Format)
+	}
+	if command == "crawl" {
+		str("--report", c.Output.Report)
+		boolean("--redirects", c.Output.Redirects)
+		boolean("--sitemaps", c.Output.Sitemaps)
+		boolean("--analytics", c.Output.Analytics)
+	}
+	if command == "sitemap" {
+		boolean("--lastmod", c.Output.LastMod)
+		num("--max-urls", c.Output.MaxUrls)
+		str("--sitemap-url", c.Output.SitemapUrl)
+	}
+	if command == "graph" {
+		str("--graph-format", c.Output.GraphFormat)
+		boolean("--by-host", c.Output.ByHost)
+		str("--nodes", c.Output.Nodes)
+	}
+	return args
+}
+
+// given is true if the flag is in argv, either on its own or with its value
+// after an =
+func given(argv []string, flag string) bool {
+	for _, arg := range argv {
+		if arg == "--" {
+			break
+		}
+		if arg == flag || strings.HasPrefix(arg, flag+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// keyError wraps an error with the key of the setting it is about
+func keyError(key string, err error) error {
+	return &KeyError{Key: key, Err: err}
+}
+
+// index returns the key of an item in a list setting
+func index(key string, i int) string {
+	return fmt.Sprintf("%s[%d]", key, i)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"tjweldon/spider/src/util"
+)
+
+const yamlConfig = `
+seeds:
+  - https://example.com/
+  - https://docs.example.com/guide/ prefix
+scope:
+  mode: host
+  allow_hosts: [cdn.example.com]
+filters:
+  exclude: ['\.pdf$']
+  max_depth: 3
+rewrite:
+  fold_slashes: true
+  rules:
+    - match: '^http:'
+      replace: 'https:'
+scrapers: [links]
+rate_limits:
+  delay: 1s
+  per_host: 4
+fetch:
+  user_agent: tester
+output:
+  report: depths
+  graph_format: dot
+`
+
+const tomlConfig = `
+seeds = ["https://example.com/", "https://docs.example.com/guide/ prefix"]
+scrapers = ["links"]
+
+[scope]
+mode = "host"
+allow_hosts = ["cdn.example.com"]
+
+[filters]
+exclude = ['\.pdf$']
+max_depth = 3
+
+[rewrite]
+fold_slashes = true
+
+[[rewrite.rules]]
+match = '^http:'
+replace = 'https:'
+
+[rate_limits]
+delay = "1s"
+per_host = 4
+
+[fetch]
+user_agent = "tester"
+
+[output]
+report = "depths"
+graph_format = "dot"
+`
+
+// writeConfig writes the config to a file with the name in a temporary
+// directory, returning its path
+func writeConfig(t *testing.T, name, config string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadYamlAndToml(t *testing.T) {
+	fromYaml, err := Load(writeConfig(t, "spider.yaml", yamlConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromToml, err := Load(writeConfig(t, "spider.toml", tomlConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromYaml, fromToml) {
+		t.Errorf("the YAML and TOML configs differ:\n%+v\n%+v", fromYaml, fromToml)
+	}
+
+	if len(fromYaml.Seeds) != 2 || fromYaml.Scope.Mode != "host" || *fromYaml.Filters.MaxDepth != 3 ||
+		!*fromYaml.Rewrite.FoldSlashes || fromYaml.Rewrite.Rules[0] != (Rule{Match: "^http:", Replace: "https:"}) {
+		t.Errorf("Load() = %+v", fromYaml)
+	}
+	if fromYaml.Filters.Limit != nil {
+		t.Errorf("Filters.Limit = %d, want settings that are left out to be nil", *fromYaml.Filters.Limit)
+	}
+}
+
+func TestLoadEmptyFile(t *testing.T) {
+	config, err := Load(writeConfig(t, "spider.yaml", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args := config.Args("crawl", nil); len(args) != 0 {
+		t.Errorf("Args() = %v for an empty config", args)
+	}
+}
+
+func TestLoadRejectsBadSettings(t *testing.T) {
+	cases := []struct {
+		name, config, key string
+	}{
+		{"spider.yaml", "scope:\n  mode: planet\n", "scope.mode"},
+		{"spider.yaml", "filters:\n  include: ['(']\n", "filters.include[0]"},
+		{"spider.yaml", "scrapers: [links, images]\n", "scrapers[1]"},
+		{"spider.yaml", "rate_limits:\n  delay: soon\n", "rate_limits.delay"},
+		{"spider.yaml", "rewrite:\n  rules:\n    - replace: x\n", "rewrite.rules[0].match"},
+		{"spider.yaml", "dedup:\n  false_positives: 2\n", "dedup.false_positives"},
+		{"spider.toml", "[output]\nformat = \"xml\"\n", "output.format"},
+		{"spider.toml", "[scope]\nmodes = \"host\"\n", "scope.modes"},
+	}
+	for _, c := range cases {
+		_, err := Load(writeConfig(t, c.name, c.config))
+		var keyErr *KeyError
+		if !errors.As(err, &keyErr) || keyErr.Key != c.key {
+			t.Errorf("Load(%q) = %v, want an error for %s", c.config, err, c.key)
+		}
+	}
+
+	if _, err := Load(writeConfig(t, "spider.yaml", "scope:\n  modes: host\n")); err == nil {
+		t.Error("Load() accepted an unknown YAML key")
+	}
+}
+
+func TestArgs(t *testing.T) {
+	config, err := Load(writeConfig(t, "spider.yaml", yamlConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"--scope=host", "--allow-host=cdn.example.com", `--exclude=\.pdf$`, "--max-depth=3",
+		"--fold-slashes=true", "--delay=1s", "--per-host=4", "--user-agent=tester", "--report=depths",
+	}
+	if args := config.Args("crawl", nil); !reflect.DeepEqual(args, want) {
+		t.Errorf("Args(crawl) = %v, want %v", args, want)
+	}
+
+	// Only the graph command takes --graph-format, and it has no --report
+	args := config.Args("graph", nil)
+	if !util.Contains(args, "--graph-format=dot") || util.Contains(args, "--report=depths") {
+		t.Errorf("Args(graph) = %v", args)
+	}
+}
+
+func TestArgsLeavesOutListsGivenOnTheCommandLine(t *testing.T) {
+	config, err := Load(writeConfig(t, "spider.yaml", yamlConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		argv     []string
+		allowed  bool
+		excluded bool
+	}{
+		{nil, true, true},
+		{[]string{"--allow-host", "cli.example.com"}, false, true},
+		{[]string{"--allow-host=cli.example.com", "--exclude=x"}, false, false},
+		{[]string{"--scope", "domain"}, true, true},
+		{[]string{"--", "--allow-host"}, true, true},
+	}
+	for _, c := range cases {
+		args := config.Args("crawl", c.argv)
+		if util.Contains(args, "--allow-host=cdn.example.com") != c.allowed ||
+			util.Contains(args, `--exclude=\.pdf$`) != c.excluded {
+			t.Errorf("Args(crawl, %v) = %v", c.argv, args)
+		}
+		if !util.Contains(args, "--scope=host") {
+			t.Errorf("Args(crawl, %v) = %v, want the scope left for the command line to override", c.argv, args)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultTimeout is how long a fetch may take, including reading the body
+	DefaultTimeout = 30 * time.Second
+
+	// DefaultMaxBodySize is how much of a response body is read
+	DefaultMaxBodySize = 10 * 1024 * 1024
+
+	// DefaultUserAgent identifies the spider to the servers it visits
+	DefaultUserAgent = "spider"
+
+	// DefaultMaxRedirects is how many redirects are followed for one fetch
+	DefaultMaxRedirects = 10
+)
+
+var (
+	// ErrTooManyRedirects is the error for a redirect chain that is longer
+	// than the Fetcher allows
+	ErrTooManyRedirects = errors.New("too many redirects")
+
+	// ErrRedirectLoop is the error for a redirect back to a url that is
+	// already in the chain
+	ErrRedirectLoop = errors.New("redirect loop")
+)
+
+// Fetcher retrieves the document at a url. Implementations must always
+// return a Result, reporting failures in Result.Err.
+type Fetcher interface {
+	Fetch(ctx context.Context, target string) *Result
+}
+
+// Prober is implemented by Fetchers that can find out about a document
+// without downloading it, with a HEAD request.
+type Prober interface {
+	Probe(ctx context.Context, target string) *Result
+}
+
+// Result is everything the pipeline gets to know about a fetch
+type Result struct {
+	// Url is the url that was requested
+	Url string
+
+	// FinalUrl is where the document was actually retrieved from, after any
+	// redirects. It is nil if no response was received.
+	FinalUrl *url.URL
+
+	// Redirects is each hop taken to get from Url to FinalUrl, in order
+	Redirects []Redirect
+
+	// Status is the HTTP status code, zero if no response was received
+	Status int
+
+	// Header is the response headers
+	Header http.Header
+
+	// Body is the response body, cut short at the Fetcher's size limit
+	Body []byte
+
+	// Truncated is true if the body was longer than the size limit
+	Truncated bool
+
+	// Started is when the request was made and Duration is how long it took
+	// to receive the whole body
+	Started  time.Time
+	Duration time.Duration
+
+	// Err is the reason the fetch failed, if it did. A response with a status
+	// outside of 2xx is a failure with a *StatusError.
+	Err error
+}
+
+// Redirect is a single hop in a redirect chain
+type Redirect struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Status int    `json:"status"`
+}
+
+// Permanent is true for the redirects that tell clients to update their
+// links, 301 and 308.
+func (r Redirect) Permanent() bool {
+	return r.Status == http.StatusMovedPermanently || r.Status == http.StatusPermanentRedirect
+}
+
+// Ok is true if a successful response was received
+func (r *Result) Ok() bool {
+	return r.Err == nil
+}
+
+// ContentType returns the Content-Type header of the response
+func (r *Result) ContentType() string {
+	if r.Header == nil {
+		return ""
+	}
+	return r.Header.Get("Content-Type")
+}
+
+// Redirected is true if the document was not found at the url requested
+func (r *Result) Redirected() bool {
+	return len(r.Redirects) > 0
+}
+
+// String is used when results are logged
+func (r *Result) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("%s: %v", r.Url, r.Err)
+	}
+	return fmt.Sprintf("%s: %d", r.Url, r.Status)
+}
+
+// MediaType returns the media type of the document, such as "text/html",
+// from the Content-Type header. If the header is missing or too vague to be
+// useful, the type is sniffed from the body instead.
+func (r *Result) MediaType() string {
+	mediaType, _, err := mime.ParseMediaType(r.ContentType())
+	if err == nil && mediaType != "application/octet-stream" {
+		return strings.ToLower(mediaType)
+	}
+	if len(r.Body) == 0 {
+		return mediaType
+	}
+	mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(r.Body))
+	return mediaType
+}
+
+// StatusError is the error for a response with a status code outside of 2xx
+type StatusError struct {
+	Status int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status))
+}
+
+// RequestError is the error for a request that could not be made at all,
+// such as for a malformed url, which no amount of retrying will fix.
+type RequestError struct {
+	Err error
+}
+
+func (e *RequestError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// HttpFetcher is the default Fetcher. It sets a User-Agent, bounds both the
+// time taken and the amount of body read, and always closes the body. It
+// follows redirects itself so that each hop is recorded in the Result.
+type HttpFetcher struct {
+	client       *http.Client
+	userAgent    string
+	maxBodySize  int64
+	maxRedirects int
+}
+
+// NewHttpFetcher returns an HttpFetcher with the default timeout, body size
+// limit and user agent.
+func NewHttpFetcher() *HttpFetcher {
+	return &HttpFetcher{
+		client:       withoutRedirects(&http.Client{Timeout: DefaultTimeout}),
+		userAgent:    DefaultUserAgent,
+		maxBodySize:  DefaultMaxBodySize,
+		maxRedirects: DefaultMaxRedirects,
+	}
+}
+
+// SetClient is a fluent setter for the http client that makes the requests.
+// A copy of the client is used, since the HttpFetcher must stop it following
+// redirects.
+func (hf *HttpFetcher) SetClient(client *http.Client) *HttpFetcher {
+	hf.client = withoutRedirects(client)
+	return hf
+}
+
+// SetMaxRedirects is a fluent setter for how many redirects are followed
+func (hf *HttpFetcher) SetMaxRedirects(max int) *HttpFetcher {
+	hf.maxRedirects = max
+	return hf
+}
+
+// SetTimeout is a fluent setter for how long a fetch may take in total
+func (hf *HttpFetcher) SetTimeout(timeout time.Duration) *HttpFetcher {
+	hf.client.Timeout = timeout
+	return hf
+}
+
+// SetUserAgent is a fluent setter for the User-Agent header
+func (hf *HttpFetcher) SetUserAgent(userAgent string) *HttpFetcher {
+	hf.userAgent = userAgent
+	return hf
+}
+
+// SetMaxBodySize is a fluent setter for how many bytes of the body are read
+func (hf *HttpFetcher) SetMaxBodySize(size int64) *HttpFetcher {
+	hf.maxBodySize = size
+	return hf
+}
+
+// Fetch is the implementation of Fetcher.Fetch
+func (hf *HttpFetcher) Fetch(ctx context.Context, target string) *Result {
+	return hf.do(ctx, http.MethodGet, target)
+}
+
+// Probe is the implementation of Prober.Probe
+func (hf *HttpFetcher) Probe(ctx context.Context, target string) *Result {
+	return hf.do(ctx, http.MethodHead, target)
+}
+
+// do makes the request, following redirects, and reads as much of the body
+// as is allowed
+func (hf *HttpFetcher) do(ctx context.Context, method, target string) *Result {
+	result := &Result{Url: target, Started: time.Now()}
+	defer func() {
+		result.Duration = time.Since(result.Started)
+	}()
+
+	current := target
+	visited := map[string]bool{target: true}
+	for {
+		req, err := http.NewRequestWithContext(ctx, method, current, nil)
+		if err != nil {
+			result.Err = &RequestError{Err: err}
+			return result
+		}
+		req.Header.Set("User-Agent", hf.userAgent)
+
+		resp, err := hf.client.Do(req)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+
+		result.FinalUrl = resp.Request.URL
+		result.Status = resp.StatusCode
+		result.Header = resp.Header
+
+		location := resp.Header.Get("Location")
+		if !isRedirect(resp.StatusCode) || location == "" {
+			hf.readBody(resp, result)
+			return result
+		}
+
+		// Read a little of the body so the connection can be reused
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		_ = resp.Body.Close()
+
+		next, err := resp.Request.URL.Parse(location)
+		if err != nil {
+			result.Err = fmt.Errorf("bad redirect location %q: %w", location, err)
+			return result
+		}
+		result.Redirects = append(result.Redirects, Redirect{
+			From: current, To: next.String(), Status: resp.StatusCode,
+		})
+
+		switch {
+		case visited[next.String()]:
+			result.Err = ErrRedirectLoop
+			return result
+		case len(result.Redirects) > hf.maxRedirects:
+			result.Err = ErrTooManyRedirects
+			return result
+		}
+		visited[next.String()] = true
+		current = next.String()
+	}
+}
+
+// readBody reads and closes the body of the final response
+func (hf *HttpFetcher) readBody(resp *http.Response, result *Result) {
+	defer resp.Body.Close()
+
+	var err error
+	result.Body, err = io.ReadAll(io.LimitReader(resp.Body, hf.maxBodySize+1))
+	if int64(len(result.Body)) > hf.maxBodySize {
+		result.Body = result.Body[:hf.maxBodySize]
+		result.Truncated = true
+	}
+	if err != nil {
+		result.Err = err
+		return
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		result.Err = &StatusError{Status: resp.StatusCode}
+	}
+}
+
+// isRedirect is true for the status codes that come with a Location to follow
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// withoutRedirects returns a copy of the client that hands back redirect
+// responses rather than following them.
+func withoutRedirects(client *http.Client) *http.Client {
+	copied := *client
+	copied.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &copied
+}
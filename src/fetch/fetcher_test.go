@@ -0,0 +1,212 @@
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveRedirects starts a server that redirects each path in hops to its
+// target with a 301, and answers every other path with a 200 and its path as
+// the body.
+func serveRedirects(t *testing.T, hops map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if next, ok := hops[r.URL.Path]; ok {
+			http.Redirect(w, r, next, http.StatusMovedPermanently)
+			return
+		}
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetchFollowsRedirects(t *testing.T) {
+	server := serveRedirects(t, map[string]string{
+		"/a":    "/b",
+		"/b":    "/c",
+		"/c":    "/d",
+		"/loop": "/back",
+		"/back": "/loop",
+		"/self": "/self",
+	})
+
+	cases := []struct {
+		name         string
+		path         string
+		maxRedirects int
+		final        string
+		hops         []string
+		err          error
+	}{
+		{"no redirect", "/d", 10, "/d", nil, nil},
+		{"chain", "/a", 10, "/d", []string{"/b", "/c", "/d"}, nil},
+		{"chain at the hop limit", "/a", 3, "/d", []string{"/b", "/c", "/d"}, nil},
+		{"chain over the hop limit", "/a", 2, "/d", []string{"/b", "/c", "/d"}, ErrTooManyRedirects},
+		{"loop", "/loop", 10, "/back", []string{"/back", "/loop"}, ErrRedirectLoop},
+		{"redirect to itself", "/self", 10, "/self", []string{"/self"}, ErrRedirectLoop},
+	}
+	for _, c := range cases {
+		fetcher := NewHttpFetcher().SetMaxRedirects(c.maxRedirects)
+		result := fetcher.Fetch(context.Background(), server.URL+c.path)
+
+		if !errors.Is(result.Err, c.err) {
+			t.Errorf("%s: Err = %v, want %v", c.name, result.Err, c.err)
+		}
+		if len(result.Redirects) != len(c.hops) {
+			t.Errorf("%s: Redirects = %v, want %d hops", c.name, result.Redirects, len(c.hops))
+			continue
+		}
+		from := server.URL + c.path
+		for i, hop := range result.Redirects {
+			want := Redirect{From: from, To: server.URL + c.hops[i], Status: http.StatusMovedPermanently}
+			if hop != want {
+				t.Errorf("%s: hop %d = %+v, want %+v", c.name, i, hop, want)
+			}
+			from = want.To
+		}
+		if c.err == nil {
+			if result.FinalUrl.String() != server.URL+c.final || string(result.Body) != c.final {
+				t.Errorf("%s: ended at %s with %q, want %s", c.name, result.FinalUrl, result.Body, c.final)
+			}
+		}
+	}
+}
+
+func TestFetchCutsTheBodyShortAtTheLimit(t *testing.T) {
+	body := bytes.Repeat([]byte("spider"), 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		limit     int64
+		size      int
+		truncated bool
+	}{
+		{1000, len(body), false},
+		{int64(len(body)), len(body), false},
+		{int64(len(body)) - 1, len(body) - 1, true},
+		{10, 10, true},
+	}
+	for _, c := range cases {
+		result := NewHttpFetcher().SetMaxBodySize(c.limit).Fetch(context.Background(), server.URL)
+		if result.Err != nil || len(result.Body) != c.size || result.Truncated != c.truncated {
+			t.Errorf("limit %d: got %d bytes, truncated %v, err %v, want %d bytes, truncated %v",
+				c.limit, len(result.Body), result.Truncated, result.Err, c.size, c.truncated)
+		}
+		if !bytes.HasPrefix(body, result.Body) {
+			t.Errorf("limit %d: the body isn't the start of what was sent", c.limit)
+		}
+	}
+}
+
+func TestFetchFailsOnStatusesOutside2xx(t *testing.T) {
+	cases := []struct {
+		status int
+		ok     bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusNoContent, true},
+		{http.StatusNotModified, false},
+		{http.StatusNotFound, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusServiceUnavailable, false},
+	}
+	for _, c := range cases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(c.status)
+		}))
+		result := NewHttpFetcher().Fetch(context.Background(), server.URL)
+		server.Close()
+
+		if result.Status != c.status || result.Ok() != c.ok {
+			t.Errorf("%d: Status = %d, Ok() = %v, want ok %v", c.status, result.Status, result.Ok(), c.ok)
+		}
+		var statusErr *StatusError
+		if !c.ok && (!errors.As(result.Err, &statusErr) || statusErr.Status != c.status) {
+			t.Errorf("%d: Err = %v, want a StatusError", c.status, result.Err)
+		}
+	}
+}
+
+func TestFetchTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	started := time.Now()
+	result := NewHttpFetcher().SetTimeout(50*time.Millisecond).Fetch(context.Background(), server.URL)
+	if !errors.Is(result.Err, context.DeadlineExceeded) {
+		t.Errorf("Err = %v, want a timeout", result.Err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("the fetch took %v to time out", elapsed)
+	}
+	if result.Status != 0 || result.FinalUrl != nil {
+		t.Errorf("Status = %d, FinalUrl = %v, want no response", result.Status, result.FinalUrl)
+	}
+}
+
+func TestFetchIsCancelledWithItsContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	result := NewHttpFetcher().Fetch(ctx, server.URL)
+	if !errors.Is(result.Err, context.Canceled) {
+		t.Errorf("Err = %v, want the fetch cancelled", result.Err)
+	}
+}
+
+func TestFetchSendsTheUserAgent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte(r.UserAgent()))
+	}))
+	defer server.Close()
+
+	if result := NewHttpFetcher().Fetch(context.Background(), server.URL); string(result.Body) != DefaultUserAgent {
+		t.Errorf("sent User-Agent %q, want %q", result.Body, DefaultUserAgent)
+	}
+	fetcher := NewHttpFetcher().SetUserAgent("tester/1.0")
+	for _, path := range []string{"/", "/redirect"} {
+		if result := fetcher.Fetch(context.Background(), server.URL+path); string(result.Body) != "tester/1.0" {
+			t.Errorf("%s: sent User-Agent %q, want tester/1.0", path, result.Body)
+		}
+	}
+}
+
+func TestProbeDoesntReadTheBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if r.Method != http.MethodHead {
+			_, _ = w.Write([]byte(strings.Repeat("x", 100)))
+		}
+	}))
+	defer server.Close()
+
+	result := NewHttpFetcher().Probe(context.Background(), server.URL)
+	if result.Err != nil || len(result.Body) != 0 || result.MediaType() != "text/html" {
+		t.Errorf("Probe() = %d bytes of %q, err %v", len(result.Body), result.MediaType(), result.Err)
+	}
+}
@@ -0,0 +1,122 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy decides whether a failed fetch is worth trying again, and how
+// long to leave it first. Delays grow exponentially from BaseDelay with
+// jitter, unless a 429 or 503 response says how long to wait in Retry-After.
+type RetryPolicy struct {
+	// MaxAttempts is the most times a url is fetched, including the first
+	MaxAttempts int
+
+	// BaseDelay is the delay before the first retry, doubling for each one
+	// after that
+	BaseDelay time.Duration
+
+	// MaxDelay caps the delay. A Retry-After asking for longer than this is
+	// not honoured, and the url is given up on.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy makes three attempts, a second and two seconds apart
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Second,
+		MaxDelay:    30 * time.Second,
+	}
+}
+
+// retryableStatuses are the response codes that may succeed if repeated
+var retryableStatuses = map[int]bool{
+	http.StatusRequestTimeout:      true,
+	http.StatusTooEarly:            true,
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// Next returns how long to wait before retrying a fetch that has been
+// attempted the passed number of times and ended with result. ok is false if
+// the fetch succeeded, can't succeed, has used up its attempts or the context
+// is done.
+func (p RetryPolicy) Next(ctx context.Context, result *Result, attempts int) (delay time.Duration, ok bool) {
+	if result.Ok() || ctx.Err() != nil || attempts >= p.MaxAttempts {
+		return 0, false
+	}
+
+	var (
+		statusErr  *StatusError
+		requestErr *RequestError
+	)
+	switch {
+	case errors.As(result.Err, &requestErr),
+		errors.Is(result.Err, ErrRedirectLoop),
+		errors.Is(result.Err, ErrTooManyRedirects):
+		return 0, false
+	case errors.As(result.Err, &statusErr):
+		if !retryableStatuses[statusErr.Status] {
+			return 0, false
+		}
+		if statusErr.Status == http.StatusTooManyRequests || statusErr.Status == http.StatusServiceUnavailable {
+			if retryAfter, found := RetryAfter(result.Header, time.Now()); found {
+				if p.MaxDelay > 0 && retryAfter > p.MaxDelay {
+					return 0, false
+				}
+				return retryAfter, true
+			}
+		}
+	}
+
+	return p.backoff(attempts), true
+}
+
+// backoff is the exponential delay with equal jitter: half the delay is
+// fixed and the other half random, so that retries from many workers spread
+// out without any of them coming back too soon.
+func (p RetryPolicy) backoff(attempts int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempts && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// RetryAfter parses the Retry-After header, which is either a number of
+// seconds or an HTTP date.
+func RetryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			seconds = 0
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := date.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,148 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// failed returns the Result of a fetch that ended with the status, and the
+// Retry-After header if one is passed
+func failed(status int, retryAfter ...string) *Result {
+	result := &Result{Status: status, Header: http.Header{}, Err: &StatusError{Status: status}}
+	for _, value := range retryAfter {
+		result.Header.Set("Retry-After", value)
+	}
+	return result
+}
+
+func TestBackoffGrowsExponentiallyWithinTheJitter(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	cases := []struct {
+		attempts int
+		delay    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{9, 10 * time.Second},
+	}
+	for _, c := range cases {
+		for i := 0; i < 100; i++ {
+			got := policy.backoff(c.attempts)
+			if got < c.delay/2 || got > c.delay {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", c.attempts, got, c.delay/2, c.delay)
+			}
+		}
+	}
+}
+
+func TestBackoffIsJittered(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second}
+	delays := map[time.Duration]bool{}
+	for i := 0; i < 20; i++ {
+		delays[policy.backoff(1)] = true
+	}
+	if len(delays) < 2 {
+		t.Errorf("backoff(1) was %v every time, want it jittered", delays)
+	}
+}
+
+func TestBackoffWithoutMaxDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: time.Millisecond}
+	if got := policy.backoff(8); got < 64*time.Millisecond || got > 128*time.Millisecond {
+		t.Errorf("backoff(8) = %v, want between 64ms and 128ms", got)
+	}
+	if got := (RetryPolicy{}).backoff(3); got != 0 {
+		t.Errorf("backoff(3) with no BaseDelay = %v, want 0", got)
+	}
+}
+
+func TestNext(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute}
+	cases := []struct {
+		name     string
+		result   *Result
+		attempts int
+		retry    bool
+	}{
+		{"success", &Result{Status: http.StatusOK}, 1, false},
+		{"server error", failed(http.StatusInternalServerError), 1, true},
+		{"server error on the last attempt", failed(http.StatusInternalServerError), 2, true},
+		{"server error with no attempts left", failed(http.StatusInternalServerError), 3, false},
+		{"not found", failed(http.StatusNotFound), 1, false},
+		{"too many requests", failed(http.StatusTooManyRequests), 1, true},
+		{"connection refused", &Result{Err: errors.New("connection refused")}, 1, true},
+		{"bad request", &Result{Err: &RequestError{Err: errors.New("bad url")}}, 1, false},
+		{"redirect loop", &Result{Err: ErrRedirectLoop}, 1, false},
+		{"too many redirects", &Result{Err: ErrTooManyRedirects}, 1, false},
+	}
+	for _, c := range cases {
+		if _, ok := policy.Next(context.Background(), c.result, c.attempts); ok != c.retry {
+			t.Errorf("%s: Next() retries = %v, want %v", c.name, ok, c.retry)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok := policy.Next(ctx, failed(http.StatusInternalServerError), 1); ok {
+		t.Error("Next() retried after the context was done")
+	}
+}
+
+func TestNextHonoursRetryAfter(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute}
+
+	delay, ok := policy.Next(context.Background(), failed(http.StatusServiceUnavailable, "7"), 1)
+	if !ok || delay != 7*time.Second {
+		t.Errorf("Next() with Retry-After: 7 = %v, %v, want 7s", delay, ok)
+	}
+
+	date := time.Now().Add(20 * time.Second).UTC().Format(http.TimeFormat)
+	delay, ok = policy.Next(context.Background(), failed(http.StatusTooManyRequests, date), 1)
+	if !ok || delay < 18*time.Second || delay > 20*time.Second {
+		t.Errorf("Next() with Retry-After: %s = %v, %v, want about 20s", date, delay, ok)
+	}
+
+	if _, ok := policy.Next(context.Background(), failed(http.StatusTooManyRequests, "120"), 1); ok {
+		t.Error("Next() retried when Retry-After asked for longer than MaxDelay")
+	}
+
+	// Only 429 and 503 say when to come back
+	delay, ok = policy.Next(context.Background(), failed(http.StatusBadGateway, "30"), 1)
+	if !ok || delay > time.Second {
+		t.Errorf("Next() for a 502 with Retry-After = %v, %v, want the backoff", delay, ok)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		value string
+		wait  time.Duration
+		found bool
+	}{
+		{"", 0, false},
+		{"0", 0, true},
+		{"120", 2 * time.Minute, true},
+		{"-5", 0, true},
+		{"Fri, 01 Mar 2024 12:00:30 GMT", 30 * time.Second, true},
+		{"Friday, 01-Mar-24 12:01:00 GMT", time.Minute, true},
+		{"Fri Mar  1 12:00:10 2024", 10 * time.Second, true},
+		{"Fri, 01 Mar 2024 11:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+	for _, c := range cases {
+		header := http.Header{}
+		if c.value != "" {
+			header.Set("Retry-After", c.value)
+		}
+		if wait, found := RetryAfter(header, now); wait != c.wait || found != c.found {
+			t.Errorf("RetryAfter(%q) = %v, %v, want %v, %v", c.value, wait, found, c.wait, c.found)
+		}
+	}
+}
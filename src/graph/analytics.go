@@ -0,0 +1,167 @@
+package graph
+
+import (
+	"math"
+	"strings"
+)
+
+const (
+	// DefaultDamping is the probability, in PageRank, that a visitor follows
+	// a link rather than jumping to a page at random
+	DefaultDamping = 0.85
+
+	// pageRankTolerance is how little the ranks may change in an iteration
+	// for them to have converged, and pageRankIterations the most that are run
+	pageRankTolerance  = 1e-9
+	pageRankIterations = 100
+)
+
+// Adjacency is the distinct nodes that each node links to
+type Adjacency map[string][]string
+
+// IsHyperlink is true for the edges that a visitor can click on
+func IsHyperlink(edge Edge) bool {
+	return edge.Element == "a" || edge.Element == "area"
+}
+
+// IsFollowed is true for the hyperlinks that pass on link equity, which are
+// those without a rel of nofollow, sponsored or ugc
+func IsFollowed(edge Edge) bool {
+	if !IsHyperlink(edge) {
+		return false
+	}
+	for _, rel := range strings.Fields(edge.Rel) {
+		if rel == "nofollow" || rel == "sponsored" || rel == "ugc" {
+			return false
+		}
+	}
+	return true
+}
+
+// Adjacency returns the edges that follow accepts as lists of the distinct
+// targets of each node. Links from a node to itself are left out.
+func (g *Graph) Adjacency(follow func(edge Edge) bool) Adjacency {
+	seen := map[[2]string]bool{}
+	adjacency := Adjacency{}
+	for _, edge := range g.Edges() {
+		pair := [2]string{edge.Source, edge.Target}
+		if edge.Source == edge.Target || seen[pair] || (follow != nil && !follow(edge)) {
+			continue
+		}
+		seen[pair] = true
+		adjacency[edge.Source] = append(adjacency[edge.Source], edge.Target)
+	}
+	return adjacency
+}
+
+// Within returns the adjacency restricted to links between the nodes
+func (a Adjacency) Within(nodes map[string]bool) Adjacency {
+	within := Adjacency{}
+	for source, targets := range a {
+		if !nodes[source] {
+			continue
+		}
+		for _, target := range targets {
+			if nodes[target] {
+				within[source] = append(within[source], target)
+			}
+		}
+	}
+	return within
+}
+
+// InDegrees counts the distinct nodes linking to each node
+func (a Adjacency) InDegrees() map[string]int {
+	degrees := map[string]int{}
+	for _, targets := range a {
+		for _, target := range targets {
+			degrees[target]++
+		}
+	}
+	return degrees
+}
+
+// PageRank ranks the nodes by the links between them, so that a node ranks
+// highly if highly ranked nodes link to it. Links to nodes outside of the
+// list are ignored, and the rank of nodes without links is shared between
+// all of them. The ranks add up to 1.
+func (a Adjacency) PageRank(nodes []string, damping float64) map[string]float64 {
+	n := float64(len(nodes))
+	ranks := make(map[string]float64, len(nodes))
+	if len(nodes) == 0 {
+		return ranks
+	}
+	included := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		included[node] = true
+		ranks[node] = 1 / n
+	}
+	within := a.Within(included)
+
+	for i := 0; i < pageRankIterations; i++ {
+		next := make(map[string]float64, len(nodes))
+		dangling := 0.0
+		for _, node := range nodes {
+			targets := within[node]
+			if len(targets) == 0 {
+				dangling += ranks[node]
+				continue
+			}
+			share := ranks[node] / float64(len(targets))
+			for _, target := range targets {
+				next[target] += share
+			}
+		}
+
+		change := 0.0
+		for _, node := range nodes {
+			rank := (1-damping)/n + damping*(next[node]+dangling/n)
+			change += math.Abs(rank - ranks[node])
+			next[node] = rank
+		}
+		ranks = next
+		if change < pageRankTolerance {
+			break
+		}
+	}
+	return ranks
+}
+
+// ClickDepths is the fewest links that have to be followed from any of the
+// seeds to reach each node, by a breadth first search. Nodes that can't be
+// reached are left out. alias, if set, gives the node that a node is
+// another name for, such as where a url redirects to, which is reached with
+// no further clicks.
+func (a Adjacency) ClickDepths(seeds []string, alias func(node string) string) map[string]int {
+	depths := map[string]int{}
+	var queue []string
+	visit := func(node string, depth int) {
+		for {
+			if _, seen := depths[node]; seen {
+				return
+			}
+			depths[node] = depth
+			queue = append(queue, node)
+			if alias == nil {
+				return
+			}
+			next := alias(node)
+			if next == node {
+				return
+			}
+			node = next
+		}
+	}
+
+	for _, seed := range seeds {
+		visit(seed, 0)
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, target := range a[node] {
+			visit(target, depths[node]+1)
+		}
+	}
+	return depths
+}
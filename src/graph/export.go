@@ -0,0 +1,168 @@
+package graph
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Formats are the names of the formats a Graph can be written in
+var Formats = []string{"graphml", "dot", "csv"}
+
+// GraphMLNamespace is the xml namespace of GraphML documents
+const GraphMLNamespace = "http://graphml.graphdrawing.org/xmlns"
+
+// WriteGraphML writes the graph as a GraphML document, with the attributes
+// of nodes and edges declared as keys.
+func (g *Graph) WriteGraphML(w io.Writer) error {
+	type key struct {
+		Id   string `xml:"id,attr"`
+		For  string `xml:"for,attr"`
+		Name string `xml:"attr.name,attr"`
+		Type string `xml:"attr.type,attr"`
+	}
+	type data struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:",chardata"`
+	}
+	type node struct {
+		Id   string `xml:"id,attr"`
+		Data []data `xml:"data"`
+	}
+	type edge struct {
+		Source string `xml:"source,attr"`
+		Target string `xml:"target,attr"`
+		Data   []data `xml:"data"`
+	}
+	type graphml struct {
+		XMLName xml.Name `xml:"graphml"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Keys    []key    `xml:"key"`
+		Graph   struct {
+			EdgeDefault string `xml:"edgedefault,attr"`
+			Nodes       []node `xml:"node"`
+			Edges       []edge `xml:"edge"`
+		} `xml:"graph"`
+	}
+
+	document := graphml{
+		Xmlns: GraphMLNamespace,
+		Keys: []key{
+			{Id: "host", For: "node", Name: "host", Type: "string"},
+			{Id: "in_scope", For: "node", Name: "in_scope", Type: "boolean"},
+			{Id: "element", For: "edge", Name: "element", Type: "string"},
+			{Id: "attribute", For: "edge", Name: "attribute", Type: "string"},
+			{Id: "rel", For: "edge", Name: "rel", Type: "string"},
+			{Id: "text", For: "edge", Name: "text", Type: "string"},
+			{Id: "weight", For: "edge", Name: "weight", Type: "int"},
+		},
+	}
+	document.Graph.EdgeDefault = "directed"
+
+	// without leaves out the attributes that are empty
+	without := func(all ...data) []data {
+		var present []data
+		for _, d := range all {
+			if d.Value != "" {
+				present = append(present, d)
+			}
+		}
+		return present
+	}
+	for _, n := range g.Nodes() {
+		document.Graph.Nodes = append(document.Graph.Nodes, node{
+			Id: n.Id,
+			Data: without(
+				data{"host", n.Host},
+				data{"in_scope", strconv.FormatBool(n.InScope)},
+			),
+		})
+	}
+	for _, e := range g.Edges() {
+		document.Graph.Edges = append(document.Graph.Edges, edge{
+			Source: e.Source,
+			Target: e.Target,
+			Data: without(
+				data{"element", e.Element},
+				data{"attribute", e.Attribute},
+				data{"rel", e.Rel},
+				data{"text", e.Text},
+				data{"weight", strconv.Itoa(e.Weight)},
+			),
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(&document); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteDot writes the graph in the Graphviz DOT language. Nodes outside of
+// the crawl's scope are dashed, and edges are labelled with their text.
+func (g *Graph) WriteDot(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "digraph links {")
+	for _, n := range g.Nodes() {
+		attrs := []string{"host=" + dotQuote(n.Host)}
+		if !n.InScope {
+			attrs = append(attrs, "style=dashed")
+		}
+		fmt.Fprintf(out, "  %s [%s];\n", dotQuote(n.Id), strings.Join(attrs, ", "))
+	}
+	for _, e := range g.Edges() {
+		attrs := []string{"weight=" + strconv.Itoa(e.Weight)}
+		for _, attr := range []struct{ name, value string }{
+			{"label", e.Text},
+			{"element", e.Element},
+			{"attribute", e.Attribute},
+			{"rel", e.Rel},
+		} {
+			if attr.value != "" {
+				attrs = append(attrs, attr.name+"="+dotQuote(attr.value))
+			}
+		}
+		fmt.Fprintf(out, "  %s -> %s [%s];\n", dotQuote(e.Source), dotQuote(e.Target), strings.Join(attrs, ", "))
+	}
+	fmt.Fprintln(out, "}")
+	return out.Flush()
+}
+
+// WriteEdgesCsv writes the edge list as CSV, with a header row
+func (g *Graph) WriteEdgesCsv(w io.Writer) error {
+	out := csv.NewWriter(w)
+	_ = out.Write([]string{"source", "target", "element", "attribute", "rel", "text", "weight"})
+	for _, e := range g.Edges() {
+		_ = out.Write([]string{
+			e.Source, e.Target, e.Element, e.Attribute, e.Rel, e.Text, strconv.Itoa(e.Weight),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// WriteNodesCsv writes the node list as CSV, with a header row
+func (g *Graph) WriteNodesCsv(w io.Writer) error {
+	out := csv.NewWriter(w)
+	_ = out.Write([]string{"id", "host", "in_scope"})
+	for _, n := range g.Nodes() {
+		_ = out.Write([]string{n.Id, n.Host, strconv.FormatBool(n.InScope)})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// dotQuote quotes a DOT identifier
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
@@ -0,0 +1,151 @@
+package graph
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"tjweldon/spider/src/links"
+)
+
+// search is a page whose url needs escaping in every format
+const search = `https://example.com/search?q=a&b="c"`
+
+// linkGraph returns a graph of two pages on example.com that link to each
+// other, twice in one direction, and to a stylesheet outside of the scope
+func linkGraph() *Graph {
+	g := New(func(pageUrl string) bool { return strings.HasPrefix(pageUrl, "https://example.com/") })
+	toSearch := links.Link{
+		Source: "https://example.com/", Target: search, Element: "a", Attribute: "href", Text: `Say "hi" & <go>`,
+	}
+	g.AddLink(toSearch)
+	g.AddLink(toSearch)
+	g.AddLink(links.Link{
+		Source: "https://example.com/", Target: "https://cdn.net/style.css", Element: "link", Attribute: "href", Rel: "stylesheet",
+	})
+	g.AddLink(links.Link{
+		Source: search, Target: "https://example.com/", Element: "a", Attribute: "href", Text: "Home",
+	})
+	return g
+}
+
+const graphML = `<?xml version="1.0" encoding="UTF-8"?>
+<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+  <key id="host" for="node" attr.name="host" attr.type="string"></key>
+  <key id="in_scope" for="node" attr.name="in_scope" attr.type="boolean"></key>
+  <key id="element" for="edge" attr.name="element" attr.type="string"></key>
+  <key id="attribute" for="edge" attr.name="attribute" attr.type="string"></key>
+  <key id="rel" for="edge" attr.name="rel" attr.type="string"></key>
+  <key id="text" for="edge" attr.name="text" attr.type="string"></key>
+  <key id="weight" for="edge" attr.name="weight" attr.type="int"></key>
+  <graph edgedefault="directed">
+    <node id="https://cdn.net/style.css">
+      <data key="host">cdn.net</data>
+      <data key="in_scope">false</data>
+    </node>
+    <node id="https://example.com/">
+      <data key="host">example.com</data>
+      <data key="in_scope">true</data>
+    </node>
+    <node id="https://example.com/search?q=a&amp;b=&#34;c&#34;">
+      <data key="host">example.com</data>
+      <data key="in_scope">true</data>
+    </node>
+    <edge source="https://example.com/" target="https://cdn.net/style.css">
+      <data key="element">link</data>
+      <data key="attribute">href</data>
+      <data key="rel">stylesheet</data>
+      <data key="weight">1</data>
+    </edge>
+    <edge source="https://example.com/" target="https://example.com/search?q=a&amp;b=&#34;c&#34;">
+      <data key="element">a</data>
+      <data key="attribute">href</data>
+      <data key="text">Say &#34;hi&#34; &amp; &lt;go&gt;</data>
+      <data key="weight">2</data>
+    </edge>
+    <edge source="https://example.com/search?q=a&amp;b=&#34;c&#34;" target="https://example.com/">
+      <data key="element">a</data>
+      <data key="attribute">href</data>
+      <data key="text">Home</data>
+      <data key="weight">1</data>
+    </edge>
+  </graph>
+</graphml>
+`
+
+const dot = `digraph links {
+  "https://cdn.net/style.css" [host="cdn.net", style=dashed];
+  "https://example.com/" [host="example.com"];
+  "https://example.com/search?q=a&b=\"c\"" [host="example.com"];
+  "https://example.com/" -> "https://cdn.net/style.css" [weight=1, element="link", attribute="href", rel="stylesheet"];
+  "https://example.com/" -> "https://example.com/search?q=a&b=\"c\"" [weight=2, label="Say \"hi\" & <go>", element="a", attribute="href"];
+  "https://example.com/search?q=a&b=\"c\"" -> "https://example.com/" [weight=1, label="Home", element="a", attribute="href"];
+}
+`
+
+const edgesCsv = `source,target,element,attribute,rel,text,weight
+https://example.com/,https://cdn.net/style.css,link,href,stylesheet,,1
+https://example.com/,"https://example.com/search?q=a&b=""c""",a,href,,"Say ""hi"" & <go>",2
+"https://example.com/search?q=a&b=""c""",https://example.com/,a,href,,Home,1
+`
+
+const nodesCsv = `id,host,in_scope
+https://cdn.net/style.css,cdn.net,false
+https://example.com/,example.com,true
+"https://example.com/search?q=a&b=""c""",example.com,true
+`
+
+const hostsDot = `digraph links {
+  "cdn.net" [host="cdn.net", style=dashed];
+  "example.com" [host="example.com"];
+  "example.com" -> "cdn.net" [weight=1];
+  "example.com" -> "example.com" [weight=3];
+}
+`
+
+const hostsEdgesCsv = `source,target,element,attribute,rel,text,weight
+example.com,cdn.net,,,,,1
+example.com,example.com,,,,,3
+`
+
+// assertWrites checks that write writes exactly the golden output
+func assertWrites(t *testing.T, name string, write func(io.Writer) error, golden string) {
+	t.Helper()
+	var out bytes.Buffer
+	if err := write(&out); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if out.String() != golden {
+		t.Errorf("%s wrote:\n%s\nwant:\n%s", name, out.String(), golden)
+	}
+}
+
+func TestExport(t *testing.T) {
+	g := linkGraph()
+	assertWrites(t, "WriteGraphML", g.WriteGraphML, graphML)
+	assertWrites(t, "WriteDot", g.WriteDot, dot)
+	assertWrites(t, "WriteEdgesCsv", g.WriteEdgesCsv, edgesCsv)
+	assertWrites(t, "WriteNodesCsv", g.WriteNodesCsv, nodesCsv)
+}
+
+func TestByHost(t *testing.T) {
+	hosts := linkGraph().ByHost()
+	assertWrites(t, "WriteDot", hosts.WriteDot, hostsDot)
+	assertWrites(t, "WriteEdgesCsv", hosts.WriteEdgesCsv, hostsEdgesCsv)
+}
+
+func TestDotQuote(t *testing.T) {
+	cases := map[string]string{
+		"":             `""`,
+		`a&b`:          `"a&b"`,
+		`say "hi"`:     `"say \"hi\""`,
+		`C:\path`:      `"C:\\path"`,
+		"two\nlines":   `"two\nlines"`,
+		`ends with \"`: `"ends with \\\""`,
+	}
+	for s, quoted := range cases {
+		if got := dotQuote(s); got != quoted {
+			t.Errorf("dotQuote(%q) = %s, want %s", s, got, quoted)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package graph
+
+import (
+	"sort"
+	"tjweldon/spider/src/links"
+	"tjweldon/spider/src/messaging"
+	"tjweldon/spider/src/util"
+)
+
+// Node is a page in the link graph, or a host if the graph is aggregated by
+// host
+type Node struct {
+	// Id is the url of the page, or the host
+	Id string
+
+	// Host is the host of the page, the same as Id for a host
+	Host string
+
+	// InScope is true for pages within the crawl's scope, and for hosts
+	// with any pages that are
+	InScope bool
+}
+
+// Edge is a link from one node to another. Identical links on the same page
+// are one edge, with a Weight of how many there are.
+type Edge struct {
+	Source string
+	Target string
+
+	// Element, Attribute, Rel and Text describe the link as in links.Link.
+	// They are empty for the edges between hosts.
+	Element   string
+	Attribute string
+	Rel       string
+	Text      string
+
+	Weight int
+}
+
+// Graph is the directed graph of the links found by a crawl
+type Graph struct {
+	inScope messaging.Validator[string]
+	nodes   map[string]*Node
+	edges   map[Edge]*Edge
+}
+
+// New returns an empty Graph. Nodes are marked as in scope if inScope
+// accepts them, or all of them are if it's nil.
+func New(inScope messaging.Validator[string]) *Graph {
+	return &Graph{
+		inScope: inScope,
+		nodes:   map[string]*Node{},
+		edges:   map[Edge]*Edge{},
+	}
+}
+
+// AddNode adds a page to the graph, if it isn't there already
+func (g *Graph) AddNode(pageUrl string) *Node {
+	if node, ok := g.nodes[pageUrl]; ok {
+		return node
+	}
+	node := &Node{
+		Id:      pageUrl,
+		Host:    util.Host(pageUrl),
+		InScope: g.inScope == nil || g.inScope(pageUrl),
+	}
+	g.nodes[pageUrl] = node
+	return node
+}
+
+// AddLink adds the link as an edge between its source and target pages
+func (g *Graph) AddLink(link links.Link) {
+	g.AddNode(link.Source)
+	g.AddNode(link.Target)
+	g.addEdge(Edge{
+		Source:    link.Source,
+		Target:    link.Target,
+		Element:   link.Element,
+		Attribute: link.Attribute,
+		Rel:       link.Rel,
+		Text:      link.Text,
+	}, 1)
+}
+
+// Node returns the node with the id, or nil if there isn't one
+func (g *Graph) Node(id string) *Node {
+	return g.nodes[id]
+}
+
+// Nodes returns every node, ordered by id
+func (g *Graph) Nodes() []Node {
+	nodes := make([]Node, 0, len(g.nodes))
+	for _, node := range g.nodes {
+		nodes = append(nodes, *node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Id < nodes[j].Id })
+	return nodes
+}
+
+// Edges returns every edge, ordered by source and then target
+func (g *Graph) Edges() []Edge {
+	edges := make([]Edge, 0, len(g.edges))
+	for _, edge := range g.edges {
+		edges = append(edges, *edge)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		a, b := edges[i], edges[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		if a.Element != b.Element {
+			return a.Element < b.Element
+		}
+		if a.Attribute != b.Attribute {
+			return a.Attribute < b.Attribute
+		}
+		if a.Rel != b.Rel {
+			return a.Rel < b.Rel
+		}
+		return a.Text < b.Text
+	})
+	return edges
+}
+
+// ByHost aggregates the graph into a graph of hosts, with an edge between
+// two hosts weighted by the number of links between their pages. Links
+// between pages on the same host become an edge from the host to itself.
+func (g *Graph) ByHost() *Graph {
+	hosts := New(nil)
+	for _, node := range g.nodes {
+		host, ok := hosts.nodes[node.Host]
+		if !ok {
+			host = &Node{Id: node.Host, Host: node.Host}
+			hosts.nodes[node.Host] = host
+		}
+		host.InScope = host.InScope || node.InScope
+	}
+	for _, edge := range g.edges {
+		hosts.addEdge(Edge{
+			Source: g.nodes[edge.Source].Host,
+			Target: g.nodes[edge.Target].Host,
+		}, edge.Weight)
+	}
+	return hosts
+}
+
+// addEdge adds weight to the edge, which is keyed by everything but its
+// weight
+func (g *Graph) addEdge(key Edge, weight int) {
+	key.Weight = 0
+	edge, ok := g.edges[key]
+	if !ok {
+		edge = &Edge{}
+		*edge = key
+		g.edges[key] = edge
+	}
+	edge.Weight += weight
+}
@@ -0,0 +1,106 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+	"tjweldon/spider/src/messaging"
+	"tjweldon/spider/src/util"
+)
+
+// Job is the envelope that a url travels through the swarm in. As well as the
+// url it records how the crawl got there.
+type Job struct {
+	// Url is the address to crawl
+	Url string
+
+	// Depth is the number of links followed from a seed to reach Url. Seeds
+	// have a depth of zero.
+	Depth int
+
+	// Referrer is the page Url was found on, empty for seeds
+	Referrer string
+
+	// Attempt is the number of times Url has already been tried and failed
+	Attempt int
+
+	// NotBefore is the earliest time a retry should be made
+	NotBefore time.Time
+}
+
+// Seed returns the Job for a url that the crawl starts from
+func Seed(url string) Job {
+	return Job{Url: url}
+}
+
+// FromSitemap returns the Job for a url listed in a sitemap. Like a seed it
+// is an entry point to the crawl, so it has a depth of zero, but the sitemap
+// is recorded as its referrer.
+func FromSitemap(url, sitemapUrl string) Job {
+	return Job{Url: url, Referrer: sitemapUrl}
+}
+
+// Follow returns the Job for a link found on this job's page. from is the
+// url the page was actually retrieved from, which may differ from Url if the
+// request was redirected.
+func (j Job) Follow(link, from string) Job {
+	return Job{Url: link, Depth: j.Depth + 1, Referrer: from}
+}
+
+// Retry returns the job to re-enqueue after a failed attempt, to be tried no
+// sooner than after the passed delay.
+func (j Job) Retry(after time.Duration) Job {
+	j.Attempt++
+	j.NotBefore = time.Now().Add(after)
+	return j
+}
+
+// IsRetry is true for jobs that have been re-enqueued after failing
+func (j Job) IsRetry() bool {
+	return j.Attempt > 0
+}
+
+// ReadyAt returns the earliest time the job should be crawled
+func (j Job) ReadyAt() time.Time {
+	return j.NotBefore
+}
+
+// Key returns the url, which is what identifies a job for deduplication
+func (j Job) Key() string {
+	return j.Url
+}
+
+// Host returns the host of the url, which is what jobs are scheduled by
+func (j Job) Host() string {
+	return util.Host(j.Url)
+}
+
+// String is used when jobs are logged
+func (j Job) String() string {
+	if j.IsRetry() {
+		return fmt.Sprintf("%s (depth %d, retry %d)", j.Url, j.Depth, j.Attempt)
+	}
+	return fmt.Sprintf("%s (depth %d)", j.Url, j.Depth)
+}
+
+// ValidateUrl adapts a Validator of urls to validate jobs
+func ValidateUrl(validator messaging.Validator[string]) messaging.Validator[Job] {
+	return func(job Job) bool {
+		return validator(job.Url)
+	}
+}
+
+// PreProcessUrl adapts a PreProcessor of urls to transform the url of jobs
+func PreProcessUrl(preProcessor messaging.PreProcessor[string]) messaging.PreProcessor[Job] {
+	return func(job Job) Job {
+		job.Url = preProcessor(job.Url)
+		return job
+	}
+}
+
+// MaxDepth is a factory for Validators that reject jobs more than max links
+// from a seed.
+func MaxDepth(max int) messaging.Validator[Job] {
+	return func(job Job) bool {
+		return job.Depth <= max
+	}
+}
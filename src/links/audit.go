@@ -0,0 +1,107 @@
+package links
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"tjweldon/spider/src/fetch"
+)
+
+// Audit keeps track of every link found during a crawl, along with the
+// result of retrieving each target, so that broken links can be traced back
+// to the pages that reference them.
+type Audit struct {
+	mutex      sync.Mutex
+	references map[string][]Link
+	results    map[string]*fetch.Result
+}
+
+// NewAudit returns an empty Audit
+func NewAudit() *Audit {
+	return &Audit{
+		references: map[string][]Link{},
+		results:    map[string]*fetch.Result{},
+	}
+}
+
+// AddLink records a reference to the link's target
+func (a *Audit) AddLink(link Link) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.references[link.Target] = append(a.references[link.Target], link)
+}
+
+// AddResult records the outcome of retrieving a url, so that it needn't be
+// checked again.
+func (a *Audit) AddResult(result *fetch.Result) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.results[result.Url] = result
+}
+
+// Unchecked returns the link targets that haven't been retrieved, such as
+// those outside the scope of the crawl, in order.
+func (a *Audit) Unchecked() []string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var targets []string
+	for target := range a.references {
+		if _, ok := a.results[target]; !ok {
+			targets = append(targets, target)
+		}
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// Check uses the checker to retrieve every target that the crawl didn't
+func (a *Audit) Check(ctx context.Context, checker *Checker) {
+	for _, result := range checker.CheckAll(ctx, a.Unchecked()) {
+		a.AddResult(result)
+	}
+}
+
+// Broken is a link whose target couldn't be retrieved
+type Broken struct {
+	Link
+	Status  int    `json:"status,omitempty"`
+	Kind    string `json:"kind"`
+	Problem string `json:"problem"`
+}
+
+// Broken returns the links whose targets couldn't be retrieved, grouped by
+// the page they were found on.
+func (a *Audit) Broken() map[string][]Broken {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	broken := map[string][]Broken{}
+	for target, references := range a.references {
+		result, ok := a.results[target]
+		if !ok || result.Ok() {
+			continue
+		}
+		for _, link := range references {
+			broken[link.Source] = append(broken[link.Source], Broken{
+				Link:    link,
+				Status:  result.Status,
+				Kind:    Kind(result),
+				Problem: result.Err.Error(),
+			})
+		}
+	}
+	for _, links := range broken {
+		sort.Slice(links, func(i, j int) bool {
+			return links[i].Target < links[j].Target
+		})
+	}
+	return broken
+}
+
+// Targets returns the number of distinct link targets
+func (a *Audit) Targets() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return len(a.references)
+}
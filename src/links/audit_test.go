@@ -0,0 +1,79 @@
+package links
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+	"tjweldon/spider/src/fetch"
+)
+
+func TestAuditBroken(t *testing.T) {
+	audit := NewAudit()
+	for _, link := range []Link{
+		{Source: "http://a.test/", Target: "http://a.test/ok"},
+		{Source: "http://a.test/", Target: "http://a.test/missing"},
+		{Source: "http://a.test/other", Target: "http://a.test/missing"},
+		{Source: "http://a.test/other", Target: "http://b.test/"},
+	} {
+		audit.AddLink(link)
+	}
+	audit.AddResult(&fetch.Result{Url: "http://a.test/ok", Status: http.StatusOK})
+	audit.AddResult(&fetch.Result{
+		Url: "http://a.test/missing", Status: http.StatusNotFound,
+		Err: &fetch.StatusError{Status: http.StatusNotFound},
+	})
+
+	if got := audit.Unchecked(); !reflect.DeepEqual(got, []string{"http://b.test/"}) {
+		t.Errorf("Unchecked() = %v", got)
+	}
+	if got := audit.Targets(); got != 3 {
+		t.Errorf("Targets() = %d, want 3", got)
+	}
+
+	broken := audit.Broken()
+	if len(broken) != 2 {
+		t.Fatalf("Broken() = %v, want the two pages linking to /missing", broken)
+	}
+	for _, source := range []string{"http://a.test/", "http://a.test/other"} {
+		links := broken[source]
+		if len(links) != 1 || links[0].Target != "http://a.test/missing" || links[0].Kind != "4xx" {
+			t.Errorf("Broken()[%s] = %v", source, links)
+		}
+	}
+}
+
+func TestAuditCheck(t *testing.T) {
+	server := serveTargets(t, map[string]int{"/ok": http.StatusOK})
+	audit := NewAudit()
+	audit.AddLink(Link{Source: "http://a.test/", Target: server.URL + "/ok"})
+	audit.AddLink(Link{Source: "http://a.test/", Target: server.URL + "/missing"})
+
+	audit.Check(context.Background(), NewChecker(fetch.NewHttpFetcher()))
+	if unchecked := audit.Unchecked(); len(unchecked) != 0 {
+		t.Errorf("Unchecked() = %v after checking", unchecked)
+	}
+	broken := audit.Broken()["http://a.test/"]
+	if len(broken) != 1 || broken[0].Target != server.URL+"/missing" {
+		t.Errorf("Broken() = %v, want just /missing", broken)
+	}
+}
+
+func TestAuditCheckLeavesCancelledTargetsUnchecked(t *testing.T) {
+	server := serveTargets(t, map[string]int{"/ok": http.StatusOK})
+	audit := NewAudit()
+	audit.AddLink(Link{Source: "http://a.test/", Target: server.URL + "/ok"})
+	audit.AddLink(Link{Source: "http://a.test/", Target: server.URL + "/slow"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	audit.Check(ctx, NewChecker(fetch.NewHttpFetcher()))
+
+	if broken := audit.Broken(); len(broken) != 0 {
+		t.Errorf("Broken() = %v after cancelling, want nothing", broken)
+	}
+	if unchecked := audit.Unchecked(); len(unchecked) == 0 {
+		t.Error("Unchecked() is empty, want the targets the cancellation cut short")
+	}
+}
@@ -0,0 +1,165 @@
+package links
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"tjweldon/spider/src/fetch"
+	"tjweldon/spider/src/messaging"
+)
+
+// Throttle is implemented by anything that can hold up a request until it is
+// polite to make it, such as a robots.Cache honouring Crawl-delay.
+type Throttle interface {
+	Wait(ctx context.Context, target string) error
+}
+
+// Checker finds out whether the targets of links can be retrieved, without
+// crawling them. It makes a HEAD request where the Fetcher supports it and
+// falls back to GET when the server doesn't answer the HEAD request properly.
+type Checker struct {
+	fetcher  fetch.Fetcher
+	throttle Throttle
+	allowed  messaging.Validator[string]
+	workers  int
+}
+
+// NewChecker returns a Checker that makes its requests with the fetcher, one
+// at a time.
+func NewChecker(fetcher fetch.Fetcher) *Checker {
+	return &Checker{fetcher: fetcher, workers: 1}
+}
+
+// SetThrottle is a fluent setter for the Throttle waited on before each request
+func (c *Checker) SetThrottle(t Throttle) *Checker {
+	c.throttle = t
+	return c
+}
+
+// SetAllowed is a fluent setter for the Validator that decides which targets
+// may be requested at all, such as a robots.Cache's. Targets it rejects are
+// left unchecked.
+func (c *Checker) SetAllowed(allowed messaging.Validator[string]) *Checker {
+	c.allowed = allowed
+	return c
+}
+
+// SetWorkers is a fluent setter for how many targets are checked at once
+func (c *Checker) SetWorkers(workers int) *Checker {
+	if workers < 1 {
+		workers = 1
+	}
+	c.workers = workers
+	return c
+}
+
+// Check retrieves a single target. If a HEAD request gets a response but
+// not a successful one, the target is fetched with GET instead, since plenty
+// of servers get HEAD wrong.
+func (c *Checker) Check(ctx context.Context, target string) *fetch.Result {
+	if err := c.wait(ctx, target); err != nil {
+		return &fetch.Result{Url: target, Err: err}
+	}
+
+	if prober, ok := c.fetcher.(fetch.Prober); ok {
+		result := prober.Probe(ctx, target)
+		if result.Ok() || result.Status == 0 {
+			return result
+		}
+		if err := c.wait(ctx, target); err != nil {
+			return &fetch.Result{Url: target, Err: err}
+		}
+	}
+	return c.fetcher.Fetch(ctx, target)
+}
+
+// CheckAll checks each of the allowed targets, returning the results by
+// target. Once the context is done no more targets are checked, and those
+// whose checks it cut short are left out, since they weren't really checked.
+func (c *Checker) CheckAll(ctx context.Context, targets []string) map[string]*fetch.Result {
+	var (
+		mutex   sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]*fetch.Result, len(targets))
+		pending = make(chan string)
+	)
+
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for target := range pending {
+				if ctx.Err() != nil {
+					continue
+				}
+				result := c.Check(ctx, target)
+				if Cancelled(ctx, result) {
+					continue
+				}
+				mutex.Lock()
+				results[target] = result
+				mutex.Unlock()
+			}
+		}()
+	}
+queue:
+	for _, target := range targets {
+		if c.allowed != nil && !c.allowed(target) {
+			continue
+		}
+		select {
+		case pending <- target:
+		case <-ctx.Done():
+			break queue
+		}
+	}
+	close(pending)
+	wg.Wait()
+
+	return results
+}
+
+// wait holds up a request to the target if there is a Throttle
+func (c *Checker) wait(ctx context.Context, target string) error {
+	if c.throttle == nil {
+		return nil
+	}
+	return c.throttle.Wait(ctx, target)
+}
+
+// Cancelled is true if the result failed because the context was done,
+// rather than because of anything to do with its target. Such a result
+// says nothing about whether the target is broken.
+func Cancelled(ctx context.Context, result *fetch.Result) bool {
+	return ctx.Err() != nil &&
+		(errors.Is(result.Err, context.Canceled) || errors.Is(result.Err, context.DeadlineExceeded))
+}
+
+// Kind classifies why a target couldn't be retrieved: "4xx", "5xx",
+// "redirect", "timeout", "dns" or "error". It is empty if the result was a success.
+func Kind(result *fetch.Result) string {
+	var (
+		statusErr *fetch.StatusError
+		dnsErr    *net.DNSError
+		netErr    net.Error
+	)
+	switch {
+	case result.Ok():
+		return ""
+	case errors.As(result.Err, &statusErr) && statusErr.Status >= 500:
+		return "5xx"
+	case errors.As(result.Err, &statusErr) && statusErr.Status >= 400:
+		return "4xx"
+	case errors.Is(result.Err, fetch.ErrRedirectLoop),
+		errors.Is(result.Err, fetch.ErrTooManyRedirects):
+		return "redirect"
+	case errors.As(result.Err, &dnsErr):
+		return "dns"
+	case errors.Is(result.Err, context.DeadlineExceeded),
+		errors.As(result.Err, &netErr) && netErr.Timeout():
+		return "timeout"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,182 @@
+package links
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	"tjweldon/spider/src/fetch"
+)
+
+// serveTargets starts a server that answers each path with its status, or a
+// 404 if it has none. HEAD requests to the paths in noHead get a 405, and
+// requests to /slow are held up until the request is cancelled.
+func serveTargets(t *testing.T, statuses map[string]int, noHead ...string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+			return
+		}
+		if r.Method == http.MethodHead {
+			for _, path := range noHead {
+				if r.URL.Path == path {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+			}
+		}
+		status, ok := statuses[r.URL.Path]
+		if !ok {
+			status = http.StatusNotFound
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// countingFetcher counts the requests made through it, by url
+type countingFetcher struct {
+	*fetch.HttpFetcher
+	mutex    sync.Mutex
+	requests map[string]int
+}
+
+func newCountingFetcher() *countingFetcher {
+	return &countingFetcher{HttpFetcher: fetch.NewHttpFetcher(), requests: map[string]int{}}
+}
+
+// Fetch is the implementation of fetch.Fetcher
+func (cf *countingFetcher) Fetch(ctx context.Context, target string) *fetch.Result {
+	cf.count(target)
+	return cf.HttpFetcher.Fetch(ctx, target)
+}
+
+// Probe is the implementation of fetch.Prober
+func (cf *countingFetcher) Probe(ctx context.Context, target string) *fetch.Result {
+	cf.count(target)
+	return cf.HttpFetcher.Probe(ctx, target)
+}
+
+func (cf *countingFetcher) count(target string) {
+	cf.mutex.Lock()
+	defer cf.mutex.Unlock()
+	cf.requests[target]++
+}
+
+func (cf *countingFetcher) Requests(target string) int {
+	cf.mutex.Lock()
+	defer cf.mutex.Unlock()
+	return cf.requests[target]
+}
+
+func TestCheck(t *testing.T) {
+	server := serveTargets(t, map[string]int{
+		"/ok":     http.StatusOK,
+		"/head":   http.StatusOK,
+		"/gone":   http.StatusGone,
+		"/broken": http.StatusInternalServerError,
+	}, "/head")
+
+	cases := []struct {
+		path   string
+		status int
+		kind   string
+	}{
+		{"/ok", http.StatusOK, ""},
+		{"/head", http.StatusOK, ""},
+		{"/gone", http.StatusGone, "4xx"},
+		{"/missing", http.StatusNotFound, "4xx"},
+		{"/broken", http.StatusInternalServerError, "5xx"},
+	}
+	checker := NewChecker(fetch.NewHttpFetcher())
+	for _, c := range cases {
+		result := checker.Check(context.Background(), server.URL+c.path)
+		if result.Status != c.status || Kind(result) != c.kind {
+			t.Errorf("Check(%s) = %d %q, want %d %q", c.path, result.Status, Kind(result), c.status, c.kind)
+		}
+	}
+}
+
+func TestKind(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		kind string
+	}{
+		{"success", nil, ""},
+		{"redirect loop", fetch.ErrRedirectLoop, "redirect"},
+		{"too many redirects", fetch.ErrTooManyRedirects, "redirect"},
+		{"dns", &net.DNSError{Err: "no such host", Name: "nowhere.test"}, "dns"},
+		{"timeout", context.DeadlineExceeded, "timeout"},
+		{"anything else", errors.New("connection refused"), "error"},
+	}
+	for _, c := range cases {
+		if got := Kind(&fetch.Result{Err: c.err}); got != c.kind {
+			t.Errorf("%s: Kind() = %q, want %q", c.name, got, c.kind)
+		}
+	}
+}
+
+func TestCheckAllSkipsTargetsThatArentAllowed(t *testing.T) {
+	server := serveTargets(t, map[string]int{"/ok": http.StatusOK, "/private": http.StatusOK})
+	fetcher := newCountingFetcher()
+	checker := NewChecker(fetcher).SetWorkers(2).SetAllowed(func(target string) bool {
+		return target != server.URL+"/private"
+	})
+
+	results := checker.CheckAll(context.Background(), []string{server.URL + "/ok", server.URL + "/private"})
+	if len(results) != 1 || results[server.URL+"/ok"] == nil {
+		t.Errorf("CheckAll() = %v, want just /ok", results)
+	}
+	if got := fetcher.Requests(server.URL + "/private"); got != 0 {
+		t.Errorf("/private was requested %d times", got)
+	}
+}
+
+func TestCheckAllStopsWhenCancelled(t *testing.T) {
+	server := serveTargets(t, map[string]int{"/ok": http.StatusOK})
+	fetcher := newCountingFetcher()
+	checker := NewChecker(fetcher)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	results := checker.CheckAll(ctx, []string{server.URL + "/slow", server.URL + "/ok"})
+
+	if len(results) != 0 {
+		t.Errorf("CheckAll() = %v after cancelling, want no results", results)
+	}
+	if got := fetcher.Requests(server.URL + "/ok"); got != 0 {
+		t.Errorf("/ok was requested %d times after cancelling", got)
+	}
+}
+
+func TestCancelled(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cases := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{"cancelled", cancelled, context.Canceled, true},
+		{"timed out", cancelled, context.DeadlineExceeded, true},
+		{"failed for another reason", cancelled, &fetch.StatusError{Status: http.StatusNotFound}, false},
+		{"timed out while running", context.Background(), context.DeadlineExceeded, false},
+	}
+	for _, c := range cases {
+		if got := Cancelled(c.ctx, &fetch.Result{Err: c.err}); got != c.want {
+			t.Errorf("%s: Cancelled() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
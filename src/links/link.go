@@ -0,0 +1,48 @@
+package links
+
+import (
+	"fmt"
+	"tjweldon/spider/src/messaging"
+)
+
+// Link is a single reference from one page to another url, as found in the
+// page's markup or stylesheet.
+type Link struct {
+	// Source is the url of the page the link was found on
+	Source string `json:"source"`
+
+	// Target is the absolute url the link points to
+	Target string `json:"target"`
+
+	// Element and Attribute are where the link was found, such as "a" and
+	// "href". Links found in CSS have an Element of "style" and no Attribute.
+	Element   string `json:"element"`
+	Attribute string `json:"attribute,omitempty"`
+
+	// Text is the anchor text of a link, or the alt text of an image
+	Text string `json:"text,omitempty"`
+
+	// Rel is the link's relationship to the page, such as "nofollow"
+	Rel string `json:"rel,omitempty"`
+}
+
+// String is used when links are logged
+func (l Link) String() string {
+	return fmt.Sprintf("%s -> %s", l.Source, l.Target)
+}
+
+// ValidateTarget adapts a Validator of urls to validate the targets of links
+func ValidateTarget(validator messaging.Validator[string]) messaging.Validator[Link] {
+	return func(link Link) bool {
+		return validator(link.Target)
+	}
+}
+
+// PreProcessTarget adapts a PreProcessor of urls to transform the targets of
+// links, so that they can be canonicalised in the same way as jobs are.
+func PreProcessTarget(preProcessor messaging.PreProcessor[string]) messaging.PreProcessor[Link] {
+	return func(link Link) Link {
+		link.Target = preProcessor(link.Target)
+		return link
+	}
+}
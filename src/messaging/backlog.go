@@ -1,34 +1,28 @@
 package messaging
 
-import "tjweldon/spider/src/util"
-
 // Backlog is the interface that the queue presents to a consumer.
 type Backlog[T any] interface {
 	Channel() <-chan T
 	Length() int
 }
 
-// ForkedBacklog is a Backlog implementation that duplexes the messages
-// so that they can be consumed by more than one consumer.
-type ForkedBacklog[T any] struct {
-	output   <-chan T
-	original Backlog[T]
-}
-
-// Channel is the implementation of Backlog.Channel
-func (fb *ForkedBacklog[T]) Channel() <-chan T {
-	return fb.output
+// Acknowledger is implemented by backlogs that need to be told when a
+// consumer has finished with a message, for example to limit how much work
+// is in progress at once.
+type Acknowledger[T any] interface {
+	Ack(item T)
 }
 
-// Length proxies back to the parent backlog
-func (fb *ForkedBacklog[T]) Length() int {
-	return fb.original.Length()
+// Drainer is implemented by backlogs that account for messages in flight,
+// from being dispatched until they are acknowledged. Once drained, such a
+// backlog closes its channel as soon as there are no messages queued or in
+// flight, since only a consumer working on a message could dispatch more.
+type Drainer interface {
+	Drain()
 }
 
-// Fork takes a Backlog and returns a pair of backlogs. The zero buffer size
-// means that the messages have to be consumed at the same rate
-func Fork[T any](original Backlog[T]) (Backlog[T], Backlog[T]) {
-	originalChannel := original.Channel()
-	c1, c2 := util.Split[T](originalChannel, 0)
-	return &ForkedBacklog[T]{c1, original}, &ForkedBacklog[T]{c2, original}
+// Stopper is implemented by backlogs that can give up on the messages they
+// hold, closing their channel without waiting for them to be consumed.
+type Stopper interface {
+	Stop()
 }
@@ -0,0 +1,282 @@
+package messaging
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+const (
+	// diskSetMagic identifies a DiskSet file
+	diskSetMagic = "SPIDSEEN"
+
+	// diskSetHeaderSize is the magic followed by the slot count
+	diskSetHeaderSize = 16
+
+	// diskSetSlotSize is the size of the 128 bit fingerprint in each slot
+	diskSetSlotSize = 16
+
+	// diskSetInitialSlots is the size of the table in a new file
+	diskSetInitialSlots = 1 << 16
+)
+
+// DiskSet is a SeenSet for strings that lives in a file rather than in
+// memory, for crawls of millions of urls. The file is an open addressing hash
+// table of 128 bit fingerprints that doubles in size whenever it becomes
+// three quarters full. Since it's on disk it also survives the process, so
+// reopening the file picks up where a previous crawl left off.
+type DiskSet struct {
+	mutex sync.Mutex
+	path  string
+	file  *os.File
+	slots uint64
+	count int
+	err   error
+}
+
+// OpenDiskSet opens the DiskSet stored at path, creating it if it doesn't
+// exist.
+func OpenDiskSet(path string) (*DiskSet, error) {
+	ds := &DiskSet{path: path}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	ds.file = file
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if info.Size() == 0 {
+		ds.slots = diskSetInitialSlots
+		err = initDiskSetFile(file, ds.slots)
+	} else {
+		ds.slots, ds.count, err = readDiskSetFile(file)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return ds, nil
+}
+
+// Has is the implementation of SeenSet.Has
+func (ds *DiskSet) Has(item string) bool {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	hi, lo := diskSetFingerprint(item)
+	_, found, err := findSlot(ds.file, ds.slots, hi, lo)
+	if err != nil {
+		ds.fail(err)
+		return false
+	}
+	return found
+}
+
+// Add is the implementation of SeenSet.Add
+func (ds *DiskSet) Add(item string) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	if uint64(ds.count+1)*4 > ds.slots*3 {
+		if err := ds.grow(); err != nil {
+			ds.fail(err)
+			return
+		}
+	}
+
+	hi, lo := diskSetFingerprint(item)
+	index, found, err := findSlot(ds.file, ds.slots, hi, lo)
+	if err != nil {
+		ds.fail(err)
+		return
+	}
+	if found {
+		return
+	}
+	if err = writeSlot(ds.file, index, hi, lo); err != nil {
+		ds.fail(err)
+		return
+	}
+	ds.count++
+}
+
+// Len is the implementation of SeenSet.Len
+func (ds *DiskSet) Len() int {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	return ds.count
+}
+
+// Err returns the first error encountered reading or writing the file. Once
+// there has been an error, items may be reported as unseen when they aren't.
+func (ds *DiskSet) Err() error {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	return ds.err
+}
+
+// Sync flushes the file to disk
+func (ds *DiskSet) Sync() error {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	return ds.file.Sync()
+}
+
+// Close flushes and closes the file
+func (ds *DiskSet) Close() error {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	if err := ds.file.Sync(); err != nil {
+		_ = ds.file.Close()
+		return err
+	}
+	return ds.file.Close()
+}
+
+// fail records and logs the first error
+func (ds *DiskSet) fail(err error) {
+	if ds.err == nil {
+		log.Printf("DiskSet %s: %v", ds.path, err)
+		ds.err = err
+	}
+}
+
+// grow rehashes the table into a file twice the size, which then replaces
+// the original.
+func (ds *DiskSet) grow() error {
+	tmpPath := ds.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	slots := ds.slots * 2
+	if err = initDiskSetFile(tmp, slots); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	err = eachSlot(ds.file, func(hi, lo uint64) error {
+		index, _, err := findSlot(tmp, slots, hi, lo)
+		if err != nil {
+			return err
+		}
+		return writeSlot(tmp, index, hi, lo)
+	})
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, ds.path)
+	}
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	_ = ds.file.Close()
+	ds.file, ds.slots = tmp, slots
+	return nil
+}
+
+// initDiskSetFile writes the header for an empty table. The slots are left to
+// the filesystem to fill with zeroes.
+func initDiskSetFile(file *os.File, slots uint64) error {
+	header := make([]byte, diskSetHeaderSize)
+	copy(header, diskSetMagic)
+	binary.BigEndian.PutUint64(header[8:], slots)
+	if _, err := file.WriteAt(header, 0); err != nil {
+		return err
+	}
+	return file.Truncate(int64(diskSetHeaderSize + slots*diskSetSlotSize))
+}
+
+// readDiskSetFile checks the header of an existing table and counts the
+// occupied slots.
+func readDiskSetFile(file *os.File) (slots uint64, count int, err error) {
+	header := make([]byte, diskSetHeaderSize)
+	if _, err = file.ReadAt(header, 0); err != nil {
+		return 0, 0, err
+	}
+	if string(header[:8]) != diskSetMagic {
+		return 0, 0, errors.New("not a seen set file")
+	}
+	slots = binary.BigEndian.Uint64(header[8:])
+
+	err = eachSlot(file, func(uint64, uint64) error {
+		count++
+		return nil
+	})
+	return slots, count, err
+}
+
+// eachSlot calls f with the fingerprint in each occupied slot of the table
+func eachSlot(file *os.File, f func(hi, lo uint64) error) error {
+	reader := bufio.NewReaderSize(
+		io.NewSectionReader(file, diskSetHeaderSize, 1<<62), 64*1024,
+	)
+	slot := make([]byte, diskSetSlotSize)
+	for {
+		if _, err := io.ReadFull(reader, slot); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		hi, lo := binary.BigEndian.Uint64(slot[:8]), binary.BigEndian.Uint64(slot[8:])
+		if hi == 0 && lo == 0 {
+			continue
+		}
+		if err := f(hi, lo); err != nil {
+			return err
+		}
+	}
+}
+
+// findSlot linearly probes the table for the fingerprint. It returns the
+// index of the slot holding it, or of the empty slot where it belongs.
+func findSlot(file *os.File, slots, hi, lo uint64) (index uint64, found bool, err error) {
+	slot := make([]byte, diskSetSlotSize)
+	index = hi % slots
+	for {
+		if _, err = file.ReadAt(slot, int64(diskSetHeaderSize+index*diskSetSlotSize)); err != nil {
+			return 0, false, err
+		}
+		slotHi, slotLo := binary.BigEndian.Uint64(slot[:8]), binary.BigEndian.Uint64(slot[8:])
+		if slotHi == 0 && slotLo == 0 {
+			return index, false, nil
+		}
+		if slotHi == hi && slotLo == lo {
+			return index, true, nil
+		}
+		index = (index + 1) % slots
+	}
+}
+
+// writeSlot stores the fingerprint in the slot at index
+func writeSlot(file *os.File, index, hi, lo uint64) error {
+	slot := make([]byte, diskSetSlotSize)
+	binary.BigEndian.PutUint64(slot[:8], hi)
+	binary.BigEndian.PutUint64(slot[8:], lo)
+	_, err := file.WriteAt(slot, int64(diskSetHeaderSize+index*diskSetSlotSize))
+	return err
+}
+
+// diskSetFingerprint is the fingerprint of the item, adjusted so that it can
+// never be mistaken for an empty slot.
+func diskSetFingerprint(item string) (uint64, uint64) {
+	hi, lo := fingerprint(item)
+	if hi == 0 && lo == 0 {
+		lo = 1
+	}
+	return hi, lo
+}
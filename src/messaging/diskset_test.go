@@ -0,0 +1,198 @@
+package messaging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openTestDiskSet opens a DiskSet in a temporary directory, returning it and
+// its path
+func openTestDiskSet(t *testing.T) (*DiskSet, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "seen")
+	set, err := OpenDiskSet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return set, path
+}
+
+func TestDiskSetAddAndHas(t *testing.T) {
+	set, _ := openTestDiskSet(t)
+	defer set.Close()
+
+	urls := benchmarkUrls(1000)
+	for _, u := range urls[:500] {
+		set.Add(u)
+	}
+	set.Add(urls[0])
+
+	for _, u := range urls[:500] {
+		if !set.Has(u) {
+			t.Errorf("Has(%q) = false after adding it", u)
+		}
+	}
+	for _, u := range urls[500:] {
+		if set.Has(u) {
+			t.Errorf("Has(%q) = true without adding it", u)
+		}
+	}
+	if set.Len() != 500 {
+		t.Errorf("Len() = %d, want 500", set.Len())
+	}
+	if err := set.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDiskSetCollisions(t *testing.T) {
+	set, _ := openTestDiskSet(t)
+	defer set.Close()
+
+	// Fingerprints that all start probing at the last slot, so the probe
+	// has to wrap around to the start of the table
+	home := set.slots - 1
+	var fingerprints [][2]uint64
+	for i := uint64(0); i < 5; i++ {
+		fingerprints = append(fingerprints, [2]uint64{i*set.slots + home, i + 1})
+	}
+
+	for i, fp := range fingerprints {
+		index, found, err := findSlot(set.file, set.slots, fp[0], fp[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found {
+			t.Fatalf("fingerprint %d found before it was written", i)
+		}
+		if want := (home + uint64(i)) % set.slots; index != want {
+			t.Errorf("fingerprint %d probed to slot %d, want %d", i, index, want)
+		}
+		if err = writeSlot(set.file, index, fp[0], fp[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, fp := range fingerprints {
+		index, found, err := findSlot(set.file, set.slots, fp[0], fp[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found || index != (home+uint64(i))%set.slots {
+			t.Errorf("fingerprint %d: found %v at slot %d", i, found, index)
+		}
+	}
+
+	// Same home slot and high half, different low half
+	_, found, err := findSlot(set.file, set.slots, fingerprints[0][0], 99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found {
+		t.Error("a fingerprint differing only in its low half was found")
+	}
+}
+
+func TestDiskSetReopen(t *testing.T) {
+	set, path := openTestDiskSet(t)
+	urls := benchmarkUrls(200)
+	for _, u := range urls[:100] {
+		set.Add(u)
+	}
+	if err := set.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenDiskSet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if reopened.Len() != 100 {
+		t.Errorf("Len() = %d after reopening, want 100", reopened.Len())
+	}
+	for _, u := range urls[:100] {
+		if !reopened.Has(u) {
+			t.Errorf("Has(%q) = false after reopening", u)
+		}
+	}
+	for _, u := range urls[100:] {
+		if reopened.Has(u) {
+			t.Errorf("Has(%q) = true after reopening, never added", u)
+		}
+	}
+}
+
+func TestDiskSetRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen")
+	if err := os.WriteFile(path, []byte("not a seen set at all"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenDiskSet(path); err == nil {
+		t.Error("OpenDiskSet accepted a file that isn't a seen set")
+	}
+}
+
+func TestDiskSetGrows(t *testing.T) {
+	// Start from a small table so that it grows a few times
+	const initialSlots = 64
+	path := filepath.Join(t.TempDir(), "seen")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = initDiskSetFile(file, initialSlots); err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+
+	set, err := OpenDiskSet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.slots != initialSlots {
+		t.Fatalf("opened with %d slots, want %d", set.slots, initialSlots)
+	}
+
+	// Enough to pass three quarters of a table four times the size
+	n := initialSlots*3 + 10
+	urls := benchmarkUrls(n)
+	for _, u := range urls {
+		set.Add(u)
+	}
+	if set.slots != 8*initialSlots {
+		t.Errorf("table has %d slots, want %d", set.slots, 8*initialSlots)
+	}
+	if set.Len() != n {
+		t.Errorf("Len() = %d, want %d", set.Len(), n)
+	}
+	for _, u := range urls {
+		if !set.Has(u) {
+			t.Fatalf("Has(%q) = false after growing", u)
+		}
+	}
+	if err = set.Err(); err != nil {
+		t.Error(err)
+	}
+	if err = set.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+
+	reopened, err := OpenDiskSet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if reopened.slots != 8*initialSlots || reopened.Len() != n {
+		t.Errorf("reopened with %d slots and %d items", reopened.slots, reopened.Len())
+	}
+	for _, u := range urls {
+		if !reopened.Has(u) {
+			t.Fatalf("Has(%q) = false after reopening the grown table", u)
+		}
+	}
+}
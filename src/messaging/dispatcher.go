@@ -1,5 +1,7 @@
 package messaging
 
+import "sync"
+
 // Dispatcher is the interface that the queue presents
 // to whichever process wants to send messages.
 type Dispatcher[T any] interface {
@@ -13,62 +15,114 @@ type Dispatcher[T any] interface {
 	Close()
 }
 
+// Claimer is implemented by dispatchers that can take responsibility for an
+// item without sending it, such as a page that was reached by a redirect.
+// Claim returns false if the item would not have been sent, because it is a
+// duplicate or is invalid.
+type Claimer[T any] interface {
+	Claim(item T) bool
+}
+
 // DeDuplicatingDispatcher is a Dispatcher implementation that
-// will silently ignore messages with identical content
-type DeDuplicatingDispatcher[T comparable] struct {
-	dispatcher    Dispatcher[T]
-	previousItems []T
-	maxJobs       int
+// will silently ignore messages with identical keys. By default
+// the key of a message is the message itself.
+type DeDuplicatingDispatcher[T any, K comparable] struct {
+	dispatcher Dispatcher[T]
+	key        func(item T) K
+	mutex      sync.Mutex
+	seen       SeenSet[K]
+	maxJobs    int
 }
 
-// WithDeDuplication wraps a dispatcher with a DeDuplicatingDispatcher
-func WithDeDuplication[T comparable](dispatcher Dispatcher[T]) *DeDuplicatingDispatcher[T] {
-	return &DeDuplicatingDispatcher[T]{
-		dispatcher:    dispatcher,
-		previousItems: []T{},
-		maxJobs:       0,
+// WithDeDuplication wraps a dispatcher with a DeDuplicatingDispatcher. The
+// items dispatched are recorded in a HashSet unless SetSeenSet is used to
+// choose another SeenSet.
+func WithDeDuplication[T comparable](dispatcher Dispatcher[T]) *DeDuplicatingDispatcher[T, T] {
+	return WithDeDuplicationBy[T, T](dispatcher, func(item T) T { return item })
+}
+
+// WithDeDuplicationBy wraps a dispatcher with a DeDuplicatingDispatcher that
+// treats messages as duplicates if the key function returns the same key for
+// them, for messages that carry more than just their identity.
+func WithDeDuplicationBy[T any, K comparable](
+	dispatcher Dispatcher[T], key func(item T) K,
+) *DeDuplicatingDispatcher[T, K] {
+	return &DeDuplicatingDispatcher[T, K]{
+		dispatcher: dispatcher,
+		key:        key,
+		seen:       NewHashSet[K](),
+		maxJobs:    0,
 	}
 }
 
 // SetMaxJobs is a fluent setter for the maximum number of unique URls after
 // which all messages are ignored.
-func (dd *DeDuplicatingDispatcher[T]) SetMaxJobs(max int) *DeDuplicatingDispatcher[T] {
+func (dd *DeDuplicatingDispatcher[T, K]) SetMaxJobs(max int) *DeDuplicatingDispatcher[T, K] {
 	dd.maxJobs = max
 	return dd
 }
 
+// SetSeenSet is a fluent setter for the SeenSet used to remember which
+// messages have already been sent.
+func (dd *DeDuplicatingDispatcher[T, K]) SetSeenSet(seen SeenSet[K]) *DeDuplicatingDispatcher[T, K] {
+	dd.seen = seen
+	return dd
+}
+
 // Dispatch implements the deduplication and job limit.
-func (dd *DeDuplicatingDispatcher[T]) Dispatch(item T) bool {
+func (dd *DeDuplicatingDispatcher[T, K]) Dispatch(item T) bool {
+	key := dd.key(item)
+	dd.mutex.Lock()
+
 	// Deduplication, ignores messages that have already been sent
-	for _, prevItem := range dd.previousItems {
-		if prevItem == item {
-			return true
-		}
+	if dd.seen.Has(key) {
+		dd.mutex.Unlock()
+		return true
 	}
 
 	// If the dispatcher has max jobs set, and we have done
 	// more than the max jobs, close the dispatcher and return.
-	if dd.maxJobs > 0 && len(dd.previousItems) >= dd.maxJobs {
+	if dd.maxJobs > 0 && dd.seen.Len() >= dd.maxJobs {
+		dd.mutex.Unlock()
 		return false
 	}
 
 	// Record the new unique message to prevent it being
 	// sent again.
-	dd.previousItems = append(dd.previousItems, item)
+	dd.seen.Add(key)
+	dd.mutex.Unlock()
 
 	// Dispatch the message
 	return dd.dispatcher.Dispatch(item)
 }
 
+// Claim records the item as sent without sending it. The job limit doesn't
+// apply, since the item is already being handled.
+func (dd *DeDuplicatingDispatcher[T, K]) Claim(item T) bool {
+	key := dd.key(item)
+	dd.mutex.Lock()
+	defer dd.mutex.Unlock()
+
+	if dd.seen.Has(key) {
+		return false
+	}
+	dd.seen.Add(key)
+	return true
+}
+
 // Close is just a proxy for everything but the underlying queue
-func (dd *DeDuplicatingDispatcher[T]) Close() {
+func (dd *DeDuplicatingDispatcher[T, K]) Close() {
 	dd.dispatcher.Close()
 }
 
-// ReportDispatched returns a slice of all of the items sent
-// by the deduplicating dispatcher
-func (dd *DeDuplicatingDispatcher[T]) ReportDispatched() []T {
-	return dd.previousItems
+// ReportDispatched returns a slice of the keys of all of the items
+// sent by the deduplicating dispatcher, or nil if the SeenSet in use
+// doesn't keep the keys themselves.
+func (dd *DeDuplicatingDispatcher[T, K]) ReportDispatched() []K {
+	if lister, ok := dd.seen.(interface{ Items() []K }); ok {
+		return lister.Items()
+	}
+	return nil
 }
 
 // Validator is a function that acts as a filter for jobs
@@ -102,6 +156,18 @@ func (vd *ValidDispatcher[T]) Dispatch(item T) (ok bool) {
 	return vd.dispatcher.Dispatch(item)
 }
 
+// Claim applies the Validators then proxies to the internal Dispatcher, if
+// it is a Claimer
+func (vd *ValidDispatcher[T]) Claim(item T) bool {
+	for _, validator := range vd.validators {
+		if !validator(item) {
+			return false
+		}
+	}
+
+	return claim(vd.dispatcher, item)
+}
+
 func (vd *ValidDispatcher[T]) Close() {
 	vd.dispatcher.Close()
 }
@@ -135,6 +201,72 @@ func (ppd *PreProcessingDispatcher[T]) Dispatch(item T) (ok bool) {
 	return ppd.dispatcher.Dispatch(item)
 }
 
+// Claim applies the PreProcessors then proxies to the internal Dispatcher,
+// if it is a Claimer
+func (ppd *PreProcessingDispatcher[T]) Claim(item T) bool {
+	for _, preProcessor := range ppd.preProcessors {
+		item = preProcessor(item)
+	}
+
+	return claim(ppd.dispatcher, item)
+}
+
 func (ppd *PreProcessingDispatcher[T]) Close() {
 	ppd.dispatcher.Close()
 }
+
+// claim proxies to dispatcher if it is a Claimer. Anything else, such as the
+// queue itself, has no reason to turn the item down.
+func claim[T any](dispatcher Dispatcher[T], item T) bool {
+	if claimer, ok := dispatcher.(Claimer[T]); ok {
+		return claimer.Claim(item)
+	}
+	return true
+}
+
+// TappedDispatcher is a Dispatcher that lets something else see each message
+// it sends, such as a report that needs to know about every job queued.
+type TappedDispatcher[T any] struct {
+	dispatcher Dispatcher[T]
+	tap        func(item T)
+}
+
+// WithTap wraps the passed dispatcher so that tap is called with each
+// message it accepts
+func WithTap[T any](dispatcher Dispatcher[T], tap func(item T)) *TappedDispatcher[T] {
+	return &TappedDispatcher[T]{
+		dispatcher: dispatcher,
+		tap:        tap,
+	}
+}
+
+// Dispatch proxies to the internal Dispatcher, and calls the tap if it
+// accepted the message
+func (td *TappedDispatcher[T]) Dispatch(item T) (ok bool) {
+	if ok = td.dispatcher.Dispatch(item); ok {
+		td.tap(item)
+	}
+	return ok
+}
+
+// Claim proxies to the internal Dispatcher, if it is a Claimer. Claimed
+// messages aren't sent, so the tap doesn't see them.
+func (td *TappedDispatcher[T]) Claim(item T) bool {
+	return claim(td.dispatcher, item)
+}
+
+func (td *TappedDispatcher[T]) Close() {
+	td.dispatcher.Close()
+}
+
+// DispatchFunc adapts a function to the Dispatcher interface, for messages
+// that are handled as soon as they are sent. There is nothing to close.
+type DispatchFunc[T any] func(item T) (ok bool)
+
+// Dispatch calls the function
+func (df DispatchFunc[T]) Dispatch(item T) (ok bool) {
+	return df(item)
+}
+
+// Close does nothing
+func (df DispatchFunc[T]) Close() {}
@@ -0,0 +1,320 @@
+package messaging
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Frontier is a Dispatcher and Backlog that keeps a journal, on disk, of the
+// messages passing through another Dispatcher and Backlog pair. Messages are
+// recorded when they are dispatched and struck off when they are
+// acknowledged, so if the process dies, reopening the journal gives back the
+// messages that were queued or still being worked on. The journal is an
+// append-only log of JSON lines that is compacted down to just those messages
+// at each Checkpoint.
+type Frontier[T any, K comparable] struct {
+	dispatcher Dispatcher[T]
+	backlog    Backlog[T]
+	key        func(item T) K
+
+	mutex   sync.Mutex
+	path    string
+	file    *os.File
+	next    uint64
+	pending map[uint64]T
+	byKey   map[K][]uint64
+	err     error
+}
+
+// frontierRecord is a line of the journal. A dispatched message is recorded
+// with its Item, and its acknowledgement with just the same Seq.
+type frontierRecord[T any] struct {
+	Seq  uint64 `json:"seq"`
+	Item *T     `json:"item,omitempty"`
+}
+
+// OpenFrontier opens the journal at path, creating it if it doesn't exist.
+// Messages are matched with their acknowledgements by the key function, so
+// if a message is dispatched again before the first one is acknowledged, as
+// a retry would be, the first one is struck off first.
+func OpenFrontier[T any, K comparable](path string, key func(item T) K) (*Frontier[T, K], error) {
+	f := &Frontier[T, K]{
+		key:     key,
+		path:    path,
+		pending: map[uint64]T{},
+		byKey:   map[K][]uint64{},
+	}
+
+	file, err := os.Open(path)
+	if err == nil {
+		err = f.replay(file)
+		_ = file.Close()
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	// Starting from a compacted journal also drops any damaged record left
+	// at the end by a crash.
+	if err := f.Checkpoint(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Split wraps the pair that the messages pass through, and dispatches to it
+// every message left over in the journal. It returns the Frontier as a
+// Dispatcher and Backlog pair to be passed to different processes.
+func (f *Frontier[T, K]) Split(dispatcher Dispatcher[T], backlog Backlog[T]) (Dispatcher[T], Backlog[T]) {
+	f.dispatcher, f.backlog = dispatcher, backlog
+	for _, seq := range f.pendingSeqs() {
+		dispatcher.Dispatch(f.pending[seq])
+	}
+	return f, f
+}
+
+// Pending returns the number of messages dispatched and not yet acknowledged
+func (f *Frontier[T, K]) Pending() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.pending)
+}
+
+// Holds is true if a message with the key has been dispatched and not yet
+// acknowledged
+func (f *Frontier[T, K]) Holds(key K) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.byKey[key]) > 0
+}
+
+// Dispatch records the message in the journal and then sends it on. The
+// message has to be recorded first, in case it is acknowledged as soon as it
+// is sent, so if the wrapped Dispatcher turns it down it is struck off again.
+func (f *Frontier[T, K]) Dispatch(item T) (ok bool) {
+	f.mutex.Lock()
+	seq := f.next
+	f.next++
+	f.pending[seq] = item
+	key := f.key(item)
+	f.byKey[key] = append(f.byKey[key], seq)
+	f.write(frontierRecord[T]{Seq: seq, Item: &item})
+	f.mutex.Unlock()
+
+	if ok = f.dispatcher.Dispatch(item); !ok {
+		f.mutex.Lock()
+		f.strike(seq)
+		f.write(frontierRecord[T]{Seq: seq})
+		f.mutex.Unlock()
+	}
+	return ok
+}
+
+// Ack strikes the message off in the journal, and passes the acknowledgement
+// on if the wrapped Backlog wants it.
+func (f *Frontier[T, K]) Ack(item T) {
+	f.mutex.Lock()
+	key := f.key(item)
+	if seqs := f.byKey[key]; len(seqs) > 0 {
+		if len(seqs) == 1 {
+			delete(f.byKey, key)
+		} else {
+			f.byKey[key] = seqs[1:]
+		}
+		delete(f.pending, seqs[0])
+		f.write(frontierRecord[T]{Seq: seqs[0]})
+	}
+	f.mutex.Unlock()
+
+	if acknowledger, ok := f.backlog.(Acknowledger[T]); ok {
+		acknowledger.Ack(item)
+	}
+}
+
+// Drain proxies to the wrapped Backlog if it can be drained
+func (f *Frontier[T, K]) Drain() {
+	if drainer, ok := f.backlog.(Drainer); ok {
+		drainer.Drain()
+	}
+}
+
+// Stop proxies to the wrapped Backlog if it can be stopped. The messages it
+// gives up on stay in the journal.
+func (f *Frontier[T, K]) Stop() {
+	if stopper, ok := f.backlog.(Stopper); ok {
+		stopper.Stop()
+	}
+}
+
+// Close proxies to the wrapped Dispatcher. The journal stays open until Save.
+func (f *Frontier[T, K]) Close() {
+	f.dispatcher.Close()
+}
+
+// Channel proxies to the wrapped Backlog
+func (f *Frontier[T, K]) Channel() <-chan T {
+	return f.backlog.Channel()
+}
+
+// Length proxies to the wrapped Backlog
+func (f *Frontier[T, K]) Length() int {
+	return f.backlog.Length()
+}
+
+// Checkpoint compacts the journal down to the messages still pending and
+// flushes it to disk. The new journal is written alongside the old one and
+// then replaces it, so a crash part way through loses nothing.
+func (f *Frontier[T, K]) Checkpoint() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	tmpPath := f.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, seq := range f.pendingSeqs() {
+		item := f.pending[seq]
+		if err = encoder.Encode(frontierRecord[T]{Seq: seq, Item: &item}); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, f.path)
+	}
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	if f.file != nil {
+		_ = f.file.Close()
+	}
+	f.file = tmp
+	return nil
+}
+
+// Save checkpoints the journal and closes it. The Frontier can't record
+// anything more afterwards.
+func (f *Frontier[T, K]) Save() error {
+	if err := f.Checkpoint(); err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// Err returns the first error encountered writing the journal. Once there
+// has been an error, the journal may be missing messages.
+func (f *Frontier[T, K]) Err() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.err
+}
+
+// write appends a record to the journal. It must be called with the mutex
+// held. Each record is written straight to the file, so that only a crash of
+// the machine rather than of the process can lose it before a Checkpoint.
+func (f *Frontier[T, K]) write(record frontierRecord[T]) {
+	if f.file == nil {
+		f.fail(errors.New("journal is closed"))
+		return
+	}
+	line, err := json.Marshal(record)
+	if err == nil {
+		_, err = f.file.Write(append(line, '\n'))
+	}
+	if err != nil {
+		f.fail(err)
+	}
+}
+
+// fail records and logs the first error
+func (f *Frontier[T, K]) fail(err error) {
+	if f.err == nil {
+		log.Printf("Frontier %s: %v", f.path, err)
+		f.err = err
+	}
+}
+
+// replay rebuilds the pending messages from a journal. Reading stops at the
+// first damaged record, which can only be the last one, cut short by a crash.
+func (f *Frontier[T, K]) replay(journal io.Reader) error {
+	reader := bufio.NewReader(journal)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(data) == 0 {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		var record frontierRecord[T]
+		if err := json.Unmarshal(data, &record); err != nil {
+			log.Printf("Frontier %s: ignoring damaged record on line %d: %v", f.path, line, err)
+			return nil
+		}
+		if record.Seq >= f.next {
+			f.next = record.Seq + 1
+		}
+		if record.Item == nil {
+			f.strike(record.Seq)
+			continue
+		}
+		f.pending[record.Seq] = *record.Item
+		key := f.key(*record.Item)
+		f.byKey[key] = append(f.byKey[key], record.Seq)
+	}
+}
+
+// strike removes the message with the sequence number from those pending. It
+// must be called with the mutex held, unless replaying.
+func (f *Frontier[T, K]) strike(seq uint64) {
+	item, ok := f.pending[seq]
+	if !ok {
+		return
+	}
+	delete(f.pending, seq)
+	key := f.key(item)
+	seqs := f.byKey[key]
+	for i, pendingSeq := range seqs {
+		if pendingSeq == seq {
+			seqs = append(seqs[:i:i], seqs[i+1:]...)
+			break
+		}
+	}
+	if len(seqs) == 0 {
+		delete(f.byKey, key)
+	} else {
+		f.byKey[key] = seqs
+	}
+}
+
+// pendingSeqs returns the sequence numbers of the pending messages in the
+// order they were dispatched
+func (f *Frontier[T, K]) pendingSeqs() []uint64 {
+	seqs := make([]uint64, 0, len(f.pending))
+	for seq := range f.pending {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs
+}
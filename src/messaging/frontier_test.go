@@ -0,0 +1,66 @@
+package messaging
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// openTestFrontier opens a Frontier of strings in a temporary directory,
+// wrapping a HostScheduler
+func openTestFrontier(t *testing.T, path string) (*Frontier[string, string], Dispatcher[string], Backlog[string]) {
+	t.Helper()
+	frontier, err := OpenFrontier[string, string](path, func(item string) string { return item })
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher, backlog := frontier.Split(NewHostScheduler[string](hostOf, 0, 0).Split())
+	return frontier, dispatcher, backlog
+}
+
+func TestFrontierKeepsUnacknowledgedMessages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "frontier.jsonl")
+	frontier, dispatcher, backlog := openTestFrontier(t, path)
+	for _, item := range []string{"a/1", "a/2", "a/3"} {
+		dispatcher.Dispatch(item)
+	}
+	item, _ := receive(t, backlog)
+	frontier.Ack(item)
+	if frontier.Holds("a/1") || !frontier.Holds("a/2") {
+		t.Errorf("Holds() is %v for the acknowledged message and %v for the rest",
+			frontier.Holds("a/1"), frontier.Holds("a/2"))
+	}
+	if err := frontier.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, _, backlog := openTestFrontier(t, path)
+	if reopened.Pending() != 2 {
+		t.Fatalf("Pending() = %d after reopening, want 2", reopened.Pending())
+	}
+	for _, want := range []string{"a/2", "a/3"} {
+		if item, _ := receive(t, backlog); item != want {
+			t.Errorf("received %q after reopening, want %s", item, want)
+		}
+	}
+}
+
+func TestFrontierForgetsRejectedMessages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "frontier.jsonl")
+	frontier, dispatcher, _ := openTestFrontier(t, path)
+	dispatcher.Close()
+	if dispatcher.Dispatch("a/1") {
+		t.Fatal("a closed scheduler accepted a message")
+	}
+	if frontier.Pending() != 0 {
+		t.Errorf("Pending() = %d, want the rejected message struck off", frontier.Pending())
+	}
+
+	// Without a checkpoint, the journal itself has to strike it off
+	reopened, err := OpenFrontier[string, string](path, func(item string) string { return item })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Pending() != 0 {
+		t.Errorf("Pending() = %d after reopening, want 0", reopened.Pending())
+	}
+}
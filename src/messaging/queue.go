@@ -1,16 +1,22 @@
 package messaging
 
 import (
-	"log"
-	"tjweldon/spider/src/util"
+	"sync"
 )
 
 // Queue is the type underlying all the dispatchers and backlogs.
 // It implements the buffered channel that is the actual message queue.
+// It also counts the messages that have been dispatched and not yet
+// acknowledged, so that it can close itself once drained and idle.
 type Queue[T any] struct {
 	input  chan T
 	output chan T
 	queue  chan T
+
+	mutex     sync.Mutex
+	pending   int
+	draining  bool
+	closeOnce sync.Once
 }
 
 // NewQ constructs a Queue with a buffer of the passed size. It returns
@@ -55,17 +61,47 @@ func (q *Queue[T]) Channel() <-chan T {
 
 // Dispatch sends messages into the write side of the send channel
 func (q *Queue[T]) Dispatch(item T) (ok bool) {
-	log.Printf("Dispatching: %v\n", item)
+	q.mutex.Lock()
+	q.pending++
+	q.mutex.Unlock()
+
 	q.input <- item
 	return true
 }
 
+// Ack records that a consumer has finished with a message
+func (q *Queue[T]) Ack(T) {
+	q.mutex.Lock()
+	if q.pending > 0 {
+		q.pending--
+	}
+	idle := q.draining && q.pending == 0
+	q.mutex.Unlock()
+
+	if idle {
+		q.Close()
+	}
+}
+
+// Drain closes the queue as soon as every message dispatched has been
+// acknowledged, which may be straight away.
+func (q *Queue[T]) Drain() {
+	q.mutex.Lock()
+	q.draining = true
+	idle := q.pending == 0
+	q.mutex.Unlock()
+
+	if idle {
+		q.Close()
+	}
+}
+
 // Close closes the input channel which cascades through the send generator, to the
 // queue and subsequently any consumers of the queue
 func (q *Queue[T]) Close() {
-	if !util.IsClosed[T](q.input) {
+	q.closeOnce.Do(func() {
 		close(q.input)
-	}
+	})
 }
 
 // Length returns the number of messages currently in the queue buffer.
@@ -0,0 +1,323 @@
+package messaging
+
+import (
+	"sync"
+	"time"
+)
+
+// HostScheduler is a Dispatcher and Backlog that keeps a separate queue for
+// each host. It hands out messages round-robin across the hosts, holding
+// back any host that was delivered to too recently or that already has too
+// many messages being worked on, so that one site can neither be hammered
+// nor starve the others. Messages count as in flight from delivery until they
+// are acknowledged, which is what lets the scheduler be drained. A host is
+// forgotten once it has nothing queued or in flight and its delay is over.
+type HostScheduler[T any] struct {
+	keyOf      func(item T) string
+	readyAt    func(item T) time.Time
+	delay      time.Duration
+	maxPerHost int
+
+	mutex    sync.Mutex
+	hosts    map[string]*hostQueue[T]
+	order    []string
+	cursor   int
+	length   int
+	inFlight int
+	closed   bool
+	draining bool
+	stopped  bool
+
+	wake   chan Signal
+	stop   chan Signal
+	output chan T
+}
+
+// Signal is an empty message used to wake a waiting goroutine
+type Signal struct{}
+
+// hostQueue is the sub-queue for a single host
+type hostQueue[T any] struct {
+	items  []T
+	active int
+	next   time.Time
+}
+
+// NewHostScheduler constructs a HostScheduler. keyOf returns the host a
+// message belongs to, delay is the minimum time between deliveries for the
+// same host and maxPerHost is the number of messages for a host that may be
+// delivered and not yet acknowledged. A maxPerHost of zero means no limit.
+func NewHostScheduler[T any](
+	keyOf func(item T) string, delay time.Duration, maxPerHost int,
+) *HostScheduler[T] {
+	return &HostScheduler[T]{
+		keyOf:      keyOf,
+		delay:      delay,
+		maxPerHost: maxPerHost,
+		hosts:      map[string]*hostQueue[T]{},
+		wake:       make(chan Signal, 1),
+		stop:       make(chan Signal),
+		output:     make(chan T),
+	}
+}
+
+// SetReadyAt is a fluent setter for a function returning the earliest time a
+// message may be delivered, for messages such as retries that must wait.
+// Messages that aren't ready are passed over for those behind them, and a
+// host with none ready is held back until one is. It must be called before
+// Split.
+func (hs *HostScheduler[T]) SetReadyAt(readyAt func(item T) time.Time) *HostScheduler[T] {
+	hs.readyAt = readyAt
+	return hs
+}
+
+// Split starts the delivery generator and returns the scheduler as a
+// Dispatcher and Backlog pair to be passed to different processes.
+func (hs *HostScheduler[T]) Split() (Dispatcher[T], Backlog[T]) {
+	go hs.deliver()
+	return hs, hs
+}
+
+// Dispatch adds the message to the back of its host's queue
+func (hs *HostScheduler[T]) Dispatch(item T) (ok bool) {
+	key := hs.keyOf(item)
+
+	hs.mutex.Lock()
+	if hs.closed {
+		hs.mutex.Unlock()
+		return false
+	}
+	queue, exists := hs.hosts[key]
+	if !exists {
+		queue = &hostQueue[T]{}
+		hs.hosts[key] = queue
+		hs.order = append(hs.order, key)
+	}
+	queue.items = append(queue.items, item)
+	hs.length++
+	hs.mutex.Unlock()
+
+	hs.notify()
+	return true
+}
+
+// Ack tells the scheduler a consumer has finished with a message, freeing
+// up a connection for its host.
+func (hs *HostScheduler[T]) Ack(item T) {
+	key := hs.keyOf(item)
+
+	hs.mutex.Lock()
+	if queue, ok := hs.hosts[key]; ok && queue.active > 0 {
+		queue.active--
+		hs.inFlight--
+	}
+	hs.mutex.Unlock()
+
+	hs.notify()
+}
+
+// Close stops the scheduler accepting messages. Messages already queued are
+// still delivered, without waiting on the host limits, after which the
+// output channel is closed.
+func (hs *HostScheduler[T]) Close() {
+	hs.mutex.Lock()
+	hs.closed = true
+	hs.mutex.Unlock()
+
+	hs.notify()
+}
+
+// Stop closes the scheduler and abandons the messages still queued, closing
+// the output channel straight away rather than waiting for a consumer to take
+// them.
+func (hs *HostScheduler[T]) Stop() {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	if hs.stopped {
+		return
+	}
+	hs.closed = true
+	hs.stopped = true
+	close(hs.stop)
+}
+
+// Drain closes the output channel as soon as there are no messages queued or
+// in flight, which may be straight away.
+func (hs *HostScheduler[T]) Drain() {
+	hs.mutex.Lock()
+	hs.draining = true
+	hs.mutex.Unlock()
+
+	hs.notify()
+}
+
+// Channel returns the read side generator channel
+func (hs *HostScheduler[T]) Channel() <-chan T {
+	return hs.output
+}
+
+// Length returns the number of messages waiting across all hosts
+func (hs *HostScheduler[T]) Length() int {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	return hs.length
+}
+
+// notify wakes the delivery generator without blocking
+func (hs *HostScheduler[T]) notify() {
+	select {
+	case hs.wake <- Signal{}:
+	default:
+	}
+}
+
+// deliver is the generator that feeds the output channel. When no host is
+// eligible it sleeps until the earliest host delay expires or something
+// changes. It gives up on whatever it is doing once the scheduler is stopped.
+func (hs *HostScheduler[T]) deliver() {
+	defer close(hs.output)
+	for {
+		item, wait, found, done := hs.next()
+		if done {
+			return
+		}
+		if found {
+			select {
+			case hs.output <- item:
+			case <-hs.stop:
+				return
+			}
+			continue
+		}
+
+		if wait <= 0 {
+			select {
+			case <-hs.wake:
+			case <-hs.stop:
+				return
+			}
+			continue
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-hs.wake:
+			timer.Stop()
+		case <-hs.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// next takes the next eligible message, moving round-robin through the hosts
+// from wherever it left off. If nothing is eligible it returns how long until
+// a host's delay expires, or zero if all waiting hosts are at their
+// connection limit. done is true once the scheduler is stopped, or is empty
+// and either closed or drained with nothing in flight.
+func (hs *HostScheduler[T]) next() (item T, wait time.Duration, found, done bool) {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+
+	now := time.Now()
+	hs.evictIdle(now)
+	if hs.stopped {
+		return item, 0, false, true
+	}
+	if hs.length == 0 {
+		return item, 0, false, hs.closed || (hs.draining && hs.inFlight == 0)
+	}
+
+	for i := range hs.order {
+		index := (hs.cursor + i) % len(hs.order)
+		queue := hs.hosts[hs.order[index]]
+		if len(queue.items) == 0 {
+			continue
+		}
+		if !hs.closed && hs.maxPerHost > 0 && queue.active >= hs.maxPerHost {
+			continue
+		}
+		position, untilNext := 0, queue.next.Sub(now)
+		if !hs.closed && untilNext <= 0 {
+			position, untilNext = hs.firstReady(queue, now)
+		}
+		if !hs.closed && untilNext > 0 {
+			if wait <= 0 || untilNext < wait {
+				wait = untilNext
+			}
+			continue
+		}
+
+		item = queue.take(position)
+		queue.active++
+		hs.inFlight++
+		queue.next = now.Add(hs.delay)
+		hs.length--
+		hs.cursor = (index + 1) % len(hs.order)
+		return item, 0, true, false
+	}
+
+	return item, wait, false, false
+}
+
+// evictIdle forgets the hosts with nothing queued or in flight whose delay is
+// over, so that a crawl across many hosts doesn't keep every one it has seen.
+// The cursor is moved back past the hosts evicted before it, so that the
+// round-robin carries on where it was.
+func (hs *HostScheduler[T]) evictIdle(now time.Time) {
+	kept, cursor := hs.order[:0], hs.cursor
+	for i, key := range hs.order {
+		queue := hs.hosts[key]
+		if len(queue.items) == 0 && queue.active == 0 && !queue.next.After(now) {
+			delete(hs.hosts, key)
+			if i < hs.cursor {
+				cursor--
+			}
+			continue
+		}
+		kept = append(kept, key)
+	}
+	for i := len(kept); i < len(hs.order); i++ {
+		hs.order[i] = ""
+	}
+	hs.order, hs.cursor = kept, cursor
+	if hs.cursor >= len(hs.order) {
+		hs.cursor = 0
+	}
+}
+
+// firstReady finds the first of the host's messages that is ready to be
+// delivered, so that a retry waiting out its backoff doesn't hold up the
+// messages queued behind it. If none are ready it returns how long until the
+// soonest one will be.
+func (hs *HostScheduler[T]) firstReady(queue *hostQueue[T], now time.Time) (position int, wait time.Duration) {
+	if hs.readyAt == nil {
+		return 0, 0
+	}
+	for i, item := range queue.items {
+		untilReady := hs.readyAt(item).Sub(now)
+		if untilReady <= 0 {
+			return i, 0
+		}
+		if i == 0 || untilReady < wait {
+			wait = untilReady
+		}
+	}
+	return 0, wait
+}
+
+// take removes the message at the position in the host's queue, keeping the
+// rest in order
+func (q *hostQueue[T]) take(position int) T {
+	item := q.items[position]
+	var zero T
+	if position == 0 {
+		q.items[0] = zero
+		q.items = q.items[1:]
+		return item
+	}
+	copy(q.items[position:], q.items[position+1:])
+	q.items[len(q.items)-1] = zero
+	q.items = q.items[:len(q.items)-1]
+	return item
+}
@@ -0,0 +1,252 @@
+package messaging
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// hostOf is the key of the test messages, the part before the slash
+func hostOf(item string) string {
+	host, _, _ := strings.Cut(item, "/")
+	return host
+}
+
+// receive takes the next message from the backlog, failing the test if none
+// comes in time
+func receive(t *testing.T, backlog Backlog[string]) (string, bool) {
+	t.Helper()
+	select {
+	case item, ok := <-backlog.Channel():
+		return item, ok
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing received from the backlog")
+		return "", false
+	}
+}
+
+// assertClosed fails the test unless the backlog's channel is closed with
+// nothing left in it
+func assertClosed(t *testing.T, backlog Backlog[string]) {
+	t.Helper()
+	if item, ok := receive(t, backlog); ok {
+		t.Fatalf("received %q, want the channel closed", item)
+	}
+}
+
+// assertOpen fails the test if anything arrives on, or closes, the backlog's
+// channel for a short while
+func assertOpen(t *testing.T, backlog Backlog[string]) {
+	t.Helper()
+	select {
+	case item, ok := <-backlog.Channel():
+		t.Fatalf("received %q (open: %v), want nothing", item, ok)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDrainEmptySchedulerClosesStraightAway(t *testing.T) {
+	scheduler := NewHostScheduler[string](hostOf, 0, 0)
+	_, backlog := scheduler.Split()
+	scheduler.Drain()
+	assertClosed(t, backlog)
+}
+
+func TestDrainWaitsForMessagesInFlight(t *testing.T) {
+	scheduler := NewHostScheduler[string](hostOf, 0, 0)
+	dispatcher, backlog := scheduler.Split()
+	dispatcher.Dispatch("a/1")
+	scheduler.Drain()
+
+	item, _ := receive(t, backlog)
+	assertOpen(t, backlog)
+
+	// Working on a message may dispatch more
+	dispatcher.Dispatch("a/2")
+	scheduler.Ack(item)
+	item, _ = receive(t, backlog)
+	if item != "a/2" {
+		t.Fatalf("received %q, want a/2", item)
+	}
+	assertOpen(t, backlog)
+
+	scheduler.Ack(item)
+	assertClosed(t, backlog)
+}
+
+func TestCloseDeliversQueuedMessagesRegardlessOfLimits(t *testing.T) {
+	scheduler := NewHostScheduler[string](hostOf, time.Hour, 1)
+	dispatcher, backlog := scheduler.Split()
+	for _, item := range []string{"a/1", "a/2", "a/3"} {
+		dispatcher.Dispatch(item)
+	}
+
+	if item, _ := receive(t, backlog); item != "a/1" {
+		t.Fatalf("received %q, want a/1", item)
+	}
+	assertOpen(t, backlog)
+
+	dispatcher.Close()
+	if dispatcher.Dispatch("a/4") {
+		t.Error("Dispatch accepted a message after Close")
+	}
+	for _, want := range []string{"a/2", "a/3"} {
+		if item, _ := receive(t, backlog); item != want {
+			t.Fatalf("received %q, want %s", item, want)
+		}
+	}
+	assertClosed(t, backlog)
+}
+
+func TestSchedulerLimitsEachHost(t *testing.T) {
+	scheduler := NewHostScheduler[string](hostOf, 0, 1)
+	dispatcher, backlog := scheduler.Split()
+	for _, item := range []string{"a/1", "a/2", "b/1"} {
+		dispatcher.Dispatch(item)
+	}
+
+	first, _ := receive(t, backlog)
+	second, _ := receive(t, backlog)
+	if first != "a/1" || second != "b/1" {
+		t.Fatalf("received %q and %q, want a/1 and b/1", first, second)
+	}
+	assertOpen(t, backlog)
+
+	scheduler.Ack(first)
+	if item, _ := receive(t, backlog); item != "a/2" {
+		t.Fatalf("received %q, want a/2", item)
+	}
+}
+
+func TestDelayedMessageDoesNotBlockItsHost(t *testing.T) {
+	ready := map[string]time.Time{"a/retry": time.Now().Add(time.Hour)}
+	scheduler := NewHostScheduler[string](hostOf, 0, 0).
+		SetReadyAt(func(item string) time.Time { return ready[item] })
+	dispatcher, backlog := scheduler.Split()
+	for _, item := range []string{"a/retry", "a/1", "a/2"} {
+		dispatcher.Dispatch(item)
+	}
+
+	for _, want := range []string{"a/1", "a/2"} {
+		if item, _ := receive(t, backlog); item != want {
+			t.Fatalf("received %q, want %s", item, want)
+		}
+	}
+	assertOpen(t, backlog)
+	if scheduler.Length() != 1 {
+		t.Errorf("Length() = %d, want the retry still queued", scheduler.Length())
+	}
+}
+
+func TestDelayedMessageIsDeliveredWhenReady(t *testing.T) {
+	ready := map[string]time.Time{
+		"a/later": time.Now().Add(200 * time.Millisecond),
+		"a/soon":  time.Now().Add(50 * time.Millisecond),
+	}
+	scheduler := NewHostScheduler[string](hostOf, 0, 0).
+		SetReadyAt(func(item string) time.Time { return ready[item] })
+	dispatcher, backlog := scheduler.Split()
+	dispatcher.Dispatch("a/later")
+	dispatcher.Dispatch("a/soon")
+
+	start := time.Now()
+	if item, _ := receive(t, backlog); item != "a/soon" {
+		t.Fatalf("received %q, want a/soon", item)
+	}
+	if item, _ := receive(t, backlog); item != "a/later" {
+		t.Fatalf("received %q, want a/later", item)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("a/later delivered after %v, before it was ready", elapsed)
+	}
+}
+
+func TestStopClosesWithoutAConsumer(t *testing.T) {
+	scheduler := NewHostScheduler[string](hostOf, 0, 0)
+	dispatcher, backlog := scheduler.Split()
+	for _, item := range []string{"a/1", "a/2", "b/1"} {
+		dispatcher.Dispatch(item)
+	}
+	// Let the generator block handing out the first message
+	time.Sleep(20 * time.Millisecond)
+
+	scheduler.Stop()
+	scheduler.Stop()
+	for received := 0; ; received++ {
+		if _, ok := receive(t, backlog); !ok {
+			break
+		}
+		if received > 0 {
+			t.Fatal("more than the message being handed out was delivered after stopping")
+		}
+	}
+	if dispatcher.Dispatch("c/1") {
+		t.Error("a stopped scheduler accepted a message")
+	}
+}
+
+func TestStopWakesAnIdleScheduler(t *testing.T) {
+	scheduler := NewHostScheduler[string](hostOf, time.Hour, 1)
+	dispatcher, backlog := scheduler.Split()
+	dispatcher.Dispatch("a/1")
+	dispatcher.Dispatch("a/2")
+	receive(t, backlog)
+	assertOpen(t, backlog)
+
+	scheduler.Stop()
+	assertClosed(t, backlog)
+}
+
+func TestSchedulerForgetsIdleHosts(t *testing.T) {
+	scheduler := NewHostScheduler[string](hostOf, 0, 0)
+	dispatcher, backlog := scheduler.Split()
+	for _, item := range []string{"a/1", "b/1", "c/1", "c/2"} {
+		dispatcher.Dispatch(item)
+	}
+	for i := 0; i < 4; i++ {
+		item, _ := receive(t, backlog)
+		scheduler.Ack(item)
+	}
+	scheduler.Drain()
+	assertClosed(t, backlog)
+
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	if len(scheduler.hosts) != 0 || len(scheduler.order) != 0 {
+		t.Errorf("%d hosts are still held, %v, want them all forgotten", len(scheduler.hosts), scheduler.order)
+	}
+}
+
+func TestSchedulerRemembersHostsUntilTheirDelayIsOver(t *testing.T) {
+	scheduler := NewHostScheduler[string](hostOf, 100*time.Millisecond, 0)
+	dispatcher, backlog := scheduler.Split()
+	dispatcher.Dispatch("a/1")
+	item, _ := receive(t, backlog)
+	scheduler.Ack(item)
+
+	// The host is idle, but forgetting it would forget its delay too
+	dispatcher.Dispatch("b/1")
+	receive(t, backlog)
+	dispatcher.Dispatch("a/2")
+	started := time.Now()
+	if item, _ := receive(t, backlog); item != "a/2" || time.Since(started) < 50*time.Millisecond {
+		t.Errorf("received %q after %v, want a/2 once the delay was over", item, time.Since(started))
+	}
+}
+
+func TestEvictingKeepsTheRoundRobinInPlace(t *testing.T) {
+	scheduler := NewHostScheduler[string](hostOf, 0, 0)
+	dispatcher, backlog := scheduler.Split()
+	for _, item := range []string{"a/1", "b/1", "b/2", "c/1", "c/2"} {
+		dispatcher.Dispatch(item)
+	}
+	var order []string
+	for i := 0; i < 5; i++ {
+		item, _ := receive(t, backlog)
+		scheduler.Ack(item)
+		order = append(order, item)
+	}
+	if got := strings.Join(order, " "); got != "a/1 b/1 c/1 b/2 c/2" {
+		t.Errorf("delivered %s, want a/1 b/1 c/1 b/2 c/2", got)
+	}
+}
@@ -0,0 +1,158 @@
+package messaging
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+// SeenSet is the record a DeDuplicatingDispatcher keeps of the items it has
+// already dispatched. Implementations trade exactness against memory.
+type SeenSet[T comparable] interface {
+	// Has returns true if the item has been added before
+	Has(item T) bool
+
+	// Add records the item as seen
+	Add(item T)
+
+	// Len returns the number of distinct items added
+	Len() int
+}
+
+// HashSet is the exact, in memory SeenSet. Lookups are O(1) but every item is
+// held for the lifetime of the crawl.
+type HashSet[T comparable] struct {
+	mutex sync.RWMutex
+	items map[T]struct{}
+	order []T
+}
+
+// NewHashSet returns an empty HashSet
+func NewHashSet[T comparable]() *HashSet[T] {
+	return &HashSet[T]{items: map[T]struct{}{}}
+}
+
+// Has is the implementation of SeenSet.Has
+func (hs *HashSet[T]) Has(item T) bool {
+	hs.mutex.RLock()
+	defer hs.mutex.RUnlock()
+	_, ok := hs.items[item]
+	return ok
+}
+
+// Add is the implementation of SeenSet.Add
+func (hs *HashSet[T]) Add(item T) {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	if _, ok := hs.items[item]; ok {
+		return
+	}
+	hs.items[item] = struct{}{}
+	hs.order = append(hs.order, item)
+}
+
+// Len is the implementation of SeenSet.Len
+func (hs *HashSet[T]) Len() int {
+	hs.mutex.RLock()
+	defer hs.mutex.RUnlock()
+	return len(hs.items)
+}
+
+// Items returns every item added, in the order they were added
+func (hs *HashSet[T]) Items() []T {
+	hs.mutex.RLock()
+	defer hs.mutex.RUnlock()
+	return append([]T{}, hs.order...)
+}
+
+// BloomFilter is a fixed size SeenSet for strings. It never forgets an item
+// but will occasionally claim to have seen one it hasn't, at a rate that is
+// chosen up front along with the number of items expected.
+type BloomFilter struct {
+	mutex  sync.RWMutex
+	bits   []uint64
+	size   uint64
+	hashes uint64
+	count  int
+}
+
+// NewBloomFilter sizes a BloomFilter so that after expectedItems have been
+// added, the chance of a false positive is falsePositiveRate.
+func NewBloomFilter(expectedItems int, falsePositiveRate float64) *BloomFilter {
+	if expectedItems < 1 {
+		expectedItems = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.001
+	}
+
+	n := float64(expectedItems)
+	size := math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	hashes := math.Max(1, math.Round(size/n*math.Ln2))
+
+	return &BloomFilter{
+		bits:   make([]uint64, (uint64(size)+63)/64),
+		size:   uint64(size),
+		hashes: uint64(hashes),
+	}
+}
+
+// Has is the implementation of SeenSet.Has. A true result may be a false
+// positive, a false result is always correct.
+func (bf *BloomFilter) Has(item string) bool {
+	bf.mutex.RLock()
+	defer bf.mutex.RUnlock()
+
+	h1, h2 := hashPair(item)
+	for i := uint64(0); i < bf.hashes; i++ {
+		bit := (h1 + i*h2) % bf.size
+		if bf.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Add is the implementation of SeenSet.Add
+func (bf *BloomFilter) Add(item string) {
+	bf.mutex.Lock()
+	defer bf.mutex.Unlock()
+
+	h1, h2 := hashPair(item)
+	added := false
+	for i := uint64(0); i < bf.hashes; i++ {
+		bit := (h1 + i*h2) % bf.size
+		if bf.bits[bit/64]&(1<<(bit%64)) == 0 {
+			bf.bits[bit/64] |= 1 << (bit % 64)
+			added = true
+		}
+	}
+	if added {
+		bf.count++
+	}
+}
+
+// Len is the implementation of SeenSet.Len. Items that collided entirely with
+// earlier ones are not counted, so this is an estimate.
+func (bf *BloomFilter) Len() int {
+	bf.mutex.RLock()
+	defer bf.mutex.RUnlock()
+	return bf.count
+}
+
+// hashPair returns two independent 64 bit hashes of the item, from which
+// Kirsch-Mitzenmacher double hashing derives as many more as are needed.
+func hashPair(item string) (uint64, uint64) {
+	h1, h2 := fingerprint(item)
+	// An even step could cycle through only some of the bits
+	return h1, h2 | 1
+}
+
+// fingerprint is the 128 bit FNV-1a hash of the item, as two halves
+func fingerprint(item string) (hi uint64, lo uint64) {
+	hasher := fnv.New128a()
+	_, _ = hasher.Write([]byte(item))
+	sum := hasher.Sum(nil)
+	return binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:])
+}
@@ -0,0 +1,98 @@
+package messaging
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// benchmarkUrls returns n distinct urls to add to a SeenSet
+func benchmarkUrls(n int) []string {
+	urls := make([]string, n)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("https://example.com/section/%d/page-%d", i%97, i)
+	}
+	return urls
+}
+
+// benchmarkAdd times adding b.N distinct urls to the set
+func benchmarkAdd(b *testing.B, set SeenSet[string]) {
+	urls := benchmarkUrls(b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		set.Add(urls[i])
+	}
+}
+
+// benchmarkHas times looking up urls in a set holding 10000, half of them
+// present and half not
+func benchmarkHas(b *testing.B, set SeenSet[string]) {
+	const size = 10000
+	urls := benchmarkUrls(2 * size)
+	for _, u := range urls[:size] {
+		set.Add(u)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		set.Has(urls[i%len(urls)])
+	}
+}
+
+// openBenchmarkDiskSet opens a DiskSet in a temporary directory that is
+// closed when the benchmark is done
+func openBenchmarkDiskSet(b *testing.B) *DiskSet {
+	set, err := OpenDiskSet(filepath.Join(b.TempDir(), "seen"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = set.Close() })
+	return set
+}
+
+func BenchmarkHashSetAdd(b *testing.B) {
+	benchmarkAdd(b, NewHashSet[string]())
+}
+
+func BenchmarkHashSetHas(b *testing.B) {
+	benchmarkHas(b, NewHashSet[string]())
+}
+
+func BenchmarkBloomFilterAdd(b *testing.B) {
+	benchmarkAdd(b, NewBloomFilter(b.N, 0.001))
+}
+
+func BenchmarkBloomFilterHas(b *testing.B) {
+	benchmarkHas(b, NewBloomFilter(10000, 0.001))
+}
+
+func BenchmarkDiskSetAdd(b *testing.B) {
+	benchmarkAdd(b, openBenchmarkDiskSet(b))
+}
+
+func BenchmarkDiskSetHas(b *testing.B) {
+	benchmarkHas(b, openBenchmarkDiskSet(b))
+}
+
+func TestBloomFilterFalsePositiveRate(t *testing.T) {
+	const n = 10000
+	filter := NewBloomFilter(n, 0.01)
+	urls := benchmarkUrls(2 * n)
+	for _, u := range urls[:n] {
+		filter.Add(u)
+	}
+	for _, u := range urls[:n] {
+		if !filter.Has(u) {
+			t.Fatalf("Has(%q) = false after adding it", u)
+		}
+	}
+
+	falsePositives := 0
+	for _, u := range urls[n:] {
+		if filter.Has(u) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / n; rate > 0.02 {
+		t.Errorf("false positive rate %.4f, want about 0.01", rate)
+	}
+}
@@ -0,0 +1,94 @@
+package records
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+	"tjweldon/spider/src/fetch"
+	"tjweldon/spider/src/jobs"
+	"tjweldon/spider/src/messaging"
+)
+
+// Page is the record of a url that was fetched, whether or not the fetch
+// succeeded
+type Page struct {
+	Url         string    `json:"url"`
+	FinalUrl    string    `json:"final_url,omitempty"`
+	Status      int       `json:"status"`
+	ContentType string    `json:"content_type,omitempty"`
+	Size        int       `json:"size"`
+	Fetched     time.Time `json:"fetched"`
+	DurationMs  float64   `json:"duration_ms"`
+	Depth       int       `json:"depth"`
+	Referrer    string    `json:"referrer,omitempty"`
+	Title       string    `json:"title,omitempty"`
+	Links       int       `json:"links"`
+	Error       string    `json:"error,omitempty"`
+
+	// Result is the outcome of the fetch, for the reports that need more
+	// than the record holds. It isn't written, so records that are read
+	// back in have none.
+	Result *fetch.Result `json:"-"`
+}
+
+// Job returns the job that the page was crawled for, as far as the record
+// tells
+func (p Page) Job() jobs.Job {
+	return jobs.Job{Url: p.Url, Depth: p.Depth, Referrer: p.Referrer}
+}
+
+// Write writes each record from the backlog to w as a line of JSON, as soon
+// as it arrives. The returned channel is sent the first error writing, if
+// there is one, and closed once the backlog is. Records keep being consumed
+// after an error so that the crawl isn't held up.
+func Write(w io.Writer, backlog messaging.Backlog[Page]) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		defer close(done)
+		var failed error
+		encoder := json.NewEncoder(w)
+		for record := range backlog.Channel() {
+			if failed != nil {
+				continue
+			}
+			if err := encoder.Encode(&record); err != nil {
+				failed = err
+			}
+		}
+		if failed != nil {
+			done <- failed
+		}
+	}()
+	return done
+}
+
+// Read calls found with each record in a JSON lines stream, as written by
+// Write. Blank lines are skipped, as is a last line that was cut short before
+// its newline, which is what a crawl leaves behind if it is killed while
+// writing a record.
+func Read(r io.Reader, found func(record Page)) error {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		last := err == io.EOF
+		if len(bytes.TrimSpace(data)) > 0 {
+			var record Page
+			if err := json.Unmarshal(data, &record); err != nil {
+				if last {
+					return nil
+				}
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			found(record)
+		}
+		if last {
+			return nil
+		}
+	}
+}
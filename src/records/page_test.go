@@ -0,0 +1,126 @@
+package records
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"tjweldon/spider/src/fetch"
+	"tjweldon/spider/src/messaging"
+)
+
+// testPages are records with every field set, or as few as possible
+var testPages = []Page{
+	{
+		Url:         "https://example.com/",
+		FinalUrl:    "https://example.com/home",
+		Status:      200,
+		ContentType: "text/html; charset=utf-8",
+		Size:        1234,
+		Fetched:     time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC),
+		DurationMs:  12.5,
+		Title:       `Home & "away"`,
+		Links:       7,
+	},
+	{
+		Url:      "https://example.com/missing",
+		Status:   404,
+		Fetched:  time.Date(2024, time.March, 1, 12, 0, 1, 0, time.UTC),
+		Depth:    1,
+		Referrer: "https://example.com/",
+		Error:    "404 Not Found",
+	},
+}
+
+// write writes the pages with Write, returning what was written
+func write(t *testing.T, pages []Page) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	dispatcher, backlog := messaging.NewQ[Page](len(pages))
+	written := Write(&out, backlog)
+	for _, page := range pages {
+		dispatcher.Dispatch(page)
+	}
+	dispatcher.Close()
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// read reads the records back with Read
+func read(t *testing.T, data []byte) ([]Page, error) {
+	t.Helper()
+	var pages []Page
+	err := Read(bytes.NewReader(data), func(page Page) {
+		pages = append(pages, page)
+	})
+	return pages, err
+}
+
+func TestWriteThenRead(t *testing.T) {
+	data := write(t, testPages)
+	if lines := strings.Count(string(data), "\n"); lines != len(testPages) {
+		t.Errorf("wrote %d lines, want one per record:\n%s", lines, data)
+	}
+
+	pages, err := read(t, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pages, testPages) {
+		t.Errorf("Read() = %+v, want %+v", pages, testPages)
+	}
+}
+
+func TestWriteLeavesOutTheResult(t *testing.T) {
+	page := testPages[0]
+	page.Result = &fetch.Result{Url: page.Url, Body: []byte("<html>")}
+	pages, err := read(t, write(t, []Page{page}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 || pages[0].Result != nil {
+		t.Errorf("Read() = %+v, want the record without its Result", pages)
+	}
+}
+
+func TestReadSkipsATruncatedLastLine(t *testing.T) {
+	data := write(t, testPages)
+	for _, cut := range []int{1, 10, len(data) / 4} {
+		truncated := append(append([]byte{}, data...), data[:cut]...)
+		pages, err := read(t, truncated)
+		if err != nil {
+			t.Errorf("Read() = %v with %d bytes of a record after the last newline", err, cut)
+		}
+		if !reflect.DeepEqual(pages, testPages) {
+			t.Errorf("Read() = %+v with a truncated last line, want %+v", pages, testPages)
+		}
+	}
+}
+
+func TestReadWithoutATrailingNewline(t *testing.T) {
+	data := bytes.TrimSuffix(write(t, testPages), []byte("\n"))
+	pages, err := read(t, data)
+	if err != nil || !reflect.DeepEqual(pages, testPages) {
+		t.Errorf("Read() = %+v, %v, want the last record read", pages, err)
+	}
+}
+
+func TestReadSkipsBlankLines(t *testing.T) {
+	data := write(t, testPages)
+	spaced := []byte("\n" + strings.Replace(string(data), "\n", "\n  \n", 1))
+	pages, err := read(t, spaced)
+	if err != nil || !reflect.DeepEqual(pages, testPages) {
+		t.Errorf("Read() = %+v, %v, want the blank lines skipped", pages, err)
+	}
+}
+
+func TestReadFailsOnACorruptLine(t *testing.T) {
+	data := write(t, testPages)
+	corrupt := append([]byte("{\"url\": broken\n"), data...)
+	if _, err := read(t, corrupt); err == nil || !strings.HasPrefix(err.Error(), "line 1:") {
+		t.Errorf("Read() = %v, want an error for line 1", err)
+	}
+}
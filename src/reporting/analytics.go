@@ -0,0 +1,147 @@
+package reporting
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"tjweldon/spider/src/graph"
+	"tjweldon/spider/src/links"
+	"tjweldon/spider/src/messaging"
+	"tjweldon/spider/src/records"
+	"tjweldon/spider/src/swarm"
+)
+
+// LinkAnalyticsReporter analyses the links between the HTML pages within
+// inScope that were crawled successfully. For each page it gives the
+// PageRank that its internal links earn it, the number of distinct pages
+// linking to it and that it links to, and how many clicks it is from the
+// nearest seed. Pages that are listed in a sitemap but can't be reached by
+// clicking from a seed are orphans, and pages that don't link to any other
+// page in scope are dead ends. Links that redirect count as links to where
+// they lead.
+type LinkAnalyticsReporter struct {
+	BaseReporter
+	seeds, listed []string
+	inScope       messaging.Validator[string]
+	found         []links.Link
+	crawled       map[string]bool
+	redirects     map[string]string
+}
+
+// NewLinkAnalyticsReporter returns a LinkAnalyticsReporter for a crawl that
+// started from the seeds
+func NewLinkAnalyticsReporter(seeds []string, inScope messaging.Validator[string]) *LinkAnalyticsReporter {
+	return &LinkAnalyticsReporter{
+		seeds:     seeds,
+		inScope:   inScope,
+		crawled:   map[string]bool{},
+		redirects: map[string]string{},
+	}
+}
+
+// SetListed is a fluent setter for the pages listed in the sitemaps, which
+// are orphans if they can't be reached. They are only needed by Finish, so
+// they can be set once the reporter is subscribed, as long as it is before
+// the bus is closed.
+func (lr *LinkAnalyticsReporter) SetListed(listed []string) *LinkAnalyticsReporter {
+	lr.listed = listed
+	return lr
+}
+
+// OnLinkFound adds the link to those analysed
+func (lr *LinkAnalyticsReporter) OnLinkFound(link links.Link) {
+	lr.found = append(lr.found, link)
+}
+
+// OnPageFetched notes where the page redirected to, and whether it is one
+// of the pages analysed
+func (lr *LinkAnalyticsReporter) OnPageFetched(page records.Page) {
+	result := page.Result
+	if result == nil || result.FinalUrl == nil {
+		return
+	}
+	final := result.FinalUrl.String()
+	if result.Redirected() {
+		lr.redirects[result.Url] = final
+	}
+	if result.Ok() && swarm.IsHtml(result.MediaType()) && (lr.inScope == nil || lr.inScope(final)) {
+		lr.crawled[final] = true
+	}
+}
+
+// Finish analyses the links, returning the analysis as JSON
+func (lr *LinkAnalyticsReporter) Finish() string {
+	type page struct {
+		Url        string  `json:"url"`
+		PageRank   float64 `json:"pagerank"`
+		InDegree   int     `json:"in_degree"`
+		OutDegree  int     `json:"out_degree"`
+		ClickDepth *int    `json:"click_depth,omitempty"`
+	}
+	type report struct {
+		Pages    []page   `json:"pages"`
+		Orphans  []string `json:"orphans"`
+		DeadEnds []string `json:"dead_ends"`
+	}
+
+	alias := func(url string) string {
+		if final, ok := lr.redirects[url]; ok {
+			return final
+		}
+		return url
+	}
+	linkGraph := graph.New(lr.inScope)
+	for _, link := range lr.found {
+		link.Target = alias(link.Target)
+		linkGraph.AddLink(link)
+	}
+
+	pages := make([]string, 0, len(lr.crawled))
+	for url := range lr.crawled {
+		pages = append(pages, url)
+	}
+	sort.Strings(pages)
+
+	hyperlinks := linkGraph.Adjacency(graph.IsHyperlink)
+	inDegrees := hyperlinks.Within(lr.crawled).InDegrees()
+	ranks := linkGraph.Adjacency(graph.IsFollowed).PageRank(pages, graph.DefaultDamping)
+	depths := hyperlinks.ClickDepths(lr.seeds, alias)
+
+	analytics := report{Pages: []page{}, Orphans: []string{}, DeadEnds: []string{}}
+	for _, url := range pages {
+		analysed := page{Url: url, PageRank: ranks[url], InDegree: inDegrees[url]}
+		for _, target := range hyperlinks[url] {
+			if node := linkGraph.Node(target); node != nil && node.InScope {
+				analysed.OutDegree++
+			}
+		}
+		if depth, ok := depths[url]; ok {
+			analysed.ClickDepth = &depth
+		}
+		if analysed.OutDegree == 0 {
+			analytics.DeadEnds = append(analytics.DeadEnds, url)
+		}
+		analytics.Pages = append(analytics.Pages, analysed)
+	}
+	sort.SliceStable(analytics.Pages, func(i, j int) bool {
+		return analytics.Pages[i].PageRank > analytics.Pages[j].PageRank
+	})
+
+	orphans := map[string]bool{}
+	for _, url := range lr.listed {
+		url = alias(url)
+		if _, reachable := depths[url]; !reachable && (lr.inScope == nil || lr.inScope(url)) {
+			orphans[url] = true
+		}
+	}
+	for url := range orphans {
+		analytics.Orphans = append(analytics.Orphans, url)
+	}
+	sort.Strings(analytics.Orphans)
+
+	result, err := json.Marshal(&analytics)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return string(result)
+}
@@ -0,0 +1,45 @@
+package reporting
+
+import (
+	"encoding/json"
+	"log"
+	"tjweldon/spider/src/jobs"
+)
+
+// DepthsReporter groups the urls queued by how many links they are from a
+// seed, along with the page each was first found on.
+type DepthsReporter struct {
+	BaseReporter
+	depths map[int][]depthEntry
+}
+
+// depthEntry is a url in the depths report
+type depthEntry struct {
+	Url      string `json:"url"`
+	Referrer string `json:"referrer,omitempty"`
+}
+
+// NewDepthsReporter returns an empty DepthsReporter
+func NewDepthsReporter() *DepthsReporter {
+	return &DepthsReporter{depths: map[int][]depthEntry{}}
+}
+
+// OnJobQueued adds the job's url at its depth
+func (dr *DepthsReporter) OnJobQueued(job jobs.Job) {
+	// Retries have been reported already
+	if job.IsRetry() {
+		return
+	}
+	dr.depths[job.Depth] = append(
+		dr.depths[job.Depth], depthEntry{Url: job.Url, Referrer: job.Referrer},
+	)
+}
+
+// Finish returns the urls at each depth as JSON
+func (dr *DepthsReporter) Finish() string {
+	result, err := json.Marshal(&dr.depths)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return string(result)
+}
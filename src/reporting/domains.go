@@ -4,35 +4,38 @@ import (
 	"encoding/json"
 	"log"
 	"net/url"
-	"tjweldon/spider/src/messaging"
+	"tjweldon/spider/src/jobs"
 )
 
-func DomainsReport(backlog messaging.Backlog[string]) <-chan string {
-	worker := func(incoming <-chan string, resultChan chan<- string) {
-		defer close(resultChan)
-		domains := map[string][]string{}
-		for msg := range incoming {
-			parsed, err := url.Parse(msg)
-			if err != nil {
-				continue
-			}
+// DomainsReporter groups the paths of the urls queued by their host
+type DomainsReporter struct {
+	BaseReporter
+	domains map[string][]string
+}
 
-			if paths, ok := domains[parsed.Host]; ok {
-				domains[parsed.Host] = append(paths, parsed.Path)
-			} else {
-				domains[parsed.Host] = []string{parsed.Path}
-			}
-		}
+// NewDomainsReporter returns an empty DomainsReporter
+func NewDomainsReporter() *DomainsReporter {
+	return &DomainsReporter{domains: map[string][]string{}}
+}
 
-		result, err := json.Marshal(&domains)
-		if err != nil {
-			log.Fatal(err)
-		}
-		resultChan <- string(result)
+// OnJobQueued adds the job's path to those of its host
+func (dr *DomainsReporter) OnJobQueued(job jobs.Job) {
+	// Retries have been reported already
+	if job.IsRetry() {
+		return
 	}
+	parsed, err := url.Parse(job.Url)
+	if err != nil {
+		return
+	}
+	dr.domains[parsed.Host] = append(dr.domains[parsed.Host], parsed.Path)
+}
 
-	output := make(chan string)
-	go worker(backlog.Channel(), output)
-
-	return output
+// Finish returns the paths of each host as JSON
+func (dr *DomainsReporter) Finish() string {
+	result, err := json.Marshal(&dr.domains)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return string(result)
 }
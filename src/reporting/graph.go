@@ -0,0 +1,68 @@
+package reporting
+
+import (
+	"io"
+	"log"
+	"strings"
+	"tjweldon/spider/src/graph"
+	"tjweldon/spider/src/links"
+	"tjweldon/spider/src/messaging"
+)
+
+// GraphSettings control how GraphReporter exports the link graph
+type GraphSettings struct {
+	// Format is one of graph.Formats
+	Format string
+
+	// ByHost aggregates the pages into their hosts
+	ByHost bool
+
+	// Nodes, if set, is where the node list goes when the Format is csv. The
+	// report itself is the edge list.
+	Nodes io.Writer
+}
+
+// GraphReporter exports the graph of the links found during the crawl, with
+// an edge from each page to every url it links to. Nodes that aren't
+// inScope are marked as such.
+type GraphReporter struct {
+	BaseReporter
+	linkGraph *graph.Graph
+	settings  GraphSettings
+}
+
+// NewGraphReporter returns a GraphReporter with no links yet
+func NewGraphReporter(inScope messaging.Validator[string], settings GraphSettings) *GraphReporter {
+	return &GraphReporter{linkGraph: graph.New(inScope), settings: settings}
+}
+
+// OnLinkFound adds the link to the graph
+func (gr *GraphReporter) OnLinkFound(link links.Link) {
+	gr.linkGraph.AddLink(link)
+}
+
+// Finish returns the graph in the format of the settings
+func (gr *GraphReporter) Finish() string {
+	linkGraph := gr.linkGraph
+	if gr.settings.ByHost {
+		linkGraph = linkGraph.ByHost()
+	}
+
+	var result strings.Builder
+	var err error
+	switch gr.settings.Format {
+	case "dot":
+		err = linkGraph.WriteDot(&result)
+	case "csv":
+		err = linkGraph.WriteEdgesCsv(&result)
+		if err == nil && gr.settings.Nodes != nil {
+			err = linkGraph.WriteNodesCsv(gr.settings.Nodes)
+		}
+	default:
+		err = linkGraph.WriteGraphML(&result)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return strings.TrimSuffix(result.String(), "\n")
+}
@@ -0,0 +1,72 @@
+package reporting
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"tjweldon/spider/src/links"
+	"tjweldon/spider/src/records"
+)
+
+// BrokenLinksReporter records the links found and the pages fetched in the
+// audit. Once the crawl is over it checks every link target the crawl didn't
+// retrieve, until the context is cancelled, then lists the broken links
+// grouped by the page they were found on. Targets that couldn't be checked,
+// because robots.txt forbids it or the context was cancelled first, are
+// counted as unchecked.
+type BrokenLinksReporter struct {
+	BaseReporter
+	ctx     context.Context
+	audit   *links.Audit
+	checker *links.Checker
+}
+
+// NewBrokenLinksReporter returns a BrokenLinksReporter that fills the audit
+// and checks the links that weren't crawled with the checker
+func NewBrokenLinksReporter(
+	ctx context.Context, audit *links.Audit, checker *links.Checker,
+) *BrokenLinksReporter {
+	return &BrokenLinksReporter{ctx: ctx, audit: audit, checker: checker}
+}
+
+// OnLinkFound adds the link to the audit
+func (br *BrokenLinksReporter) OnLinkFound(link links.Link) {
+	br.audit.AddLink(link)
+}
+
+// OnPageFetched adds the outcome of fetching the page to the audit, so that
+// it needn't be checked again. Fetches cut short by cancelling the crawl are
+// left out, leaving their targets unchecked rather than broken.
+func (br *BrokenLinksReporter) OnPageFetched(page records.Page) {
+	if page.Result != nil && !links.Cancelled(br.ctx, page.Result) {
+		br.audit.AddResult(page.Result)
+	}
+}
+
+// Finish checks the links that weren't crawled and returns the broken ones
+// as JSON
+func (br *BrokenLinksReporter) Finish() string {
+	type report struct {
+		Checked   int                       `json:"checked"`
+		Unchecked int                       `json:"unchecked,omitempty"`
+		Broken    int                       `json:"broken"`
+		Pages     map[string][]links.Broken `json:"pages"`
+	}
+
+	br.audit.Check(br.ctx, br.checker)
+	unchecked := len(br.audit.Unchecked())
+	broken := report{
+		Checked:   br.audit.Targets() - unchecked,
+		Unchecked: unchecked,
+		Pages:     br.audit.Broken(),
+	}
+	for _, page := range broken.Pages {
+		broken.Broken += len(page)
+	}
+
+	result, err := json.Marshal(&broken)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return string(result)
+}
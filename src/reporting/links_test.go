@@ -0,0 +1,27 @@
+package reporting
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"tjweldon/spider/src/fetch"
+	"tjweldon/spider/src/links"
+	"tjweldon/spider/src/records"
+)
+
+func TestBrokenLinksReporterIgnoresCancelledFetches(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	reporter := NewBrokenLinksReporter(ctx, links.NewAudit(), links.NewChecker(fetch.NewHttpFetcher()))
+
+	reporter.OnLinkFound(links.Link{Source: "http://a.test/", Target: "http://a.test/page"})
+	reporter.OnPageFetched(records.Page{
+		Url:    "http://a.test/page",
+		Result: &fetch.Result{Url: "http://a.test/page", Err: context.Canceled},
+	})
+
+	report := reporter.Finish()
+	if !strings.Contains(report, `"unchecked":1`) || !strings.Contains(report, `"broken":0`) {
+		t.Errorf("report = %s, want the cancelled page unchecked rather than broken", report)
+	}
+}
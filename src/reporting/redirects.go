@@ -0,0 +1,91 @@
+package reporting
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"tjweldon/spider/src/fetch"
+	"tjweldon/spider/src/messaging"
+	"tjweldon/spider/src/records"
+)
+
+// RedirectsReporter lists every redirect chain followed during the crawl,
+// with a count of the hops taken by status code so that temporary redirects
+// that ought to be permanent stand out. Chains that end outside of inScope
+// are flagged and listed separately.
+type RedirectsReporter struct {
+	BaseReporter
+	inScope   messaging.Validator[string]
+	redirects redirectsReport
+}
+
+// redirectsReport is the JSON of the RedirectsReporter
+type redirectsReport struct {
+	Chains       []redirectChain `json:"chains"`
+	Statuses     map[string]int  `json:"statuses"`
+	LeavingScope []string        `json:"leaving_scope"`
+}
+
+// redirectChain is a url that was redirected, and where it ended up
+type redirectChain struct {
+	Url         string           `json:"url"`
+	Final       string           `json:"final"`
+	Hops        []fetch.Redirect `json:"hops"`
+	Permanent   bool             `json:"permanent"`
+	LeavesScope bool             `json:"leaves_scope,omitempty"`
+	Error       string           `json:"error,omitempty"`
+}
+
+// NewRedirectsReporter returns an empty RedirectsReporter
+func NewRedirectsReporter(inScope messaging.Validator[string]) *RedirectsReporter {
+	return &RedirectsReporter{
+		inScope: inScope,
+		redirects: redirectsReport{
+			Chains:       []redirectChain{},
+			Statuses:     map[string]int{},
+			LeavingScope: []string{},
+		},
+	}
+}
+
+// OnPageFetched adds the chain of redirects followed to the page, if any
+func (rr *RedirectsReporter) OnPageFetched(page records.Page) {
+	result := page.Result
+	if result == nil || !result.Redirected() {
+		return
+	}
+
+	found := redirectChain{
+		Url:       result.Url,
+		Final:     result.Redirects[len(result.Redirects)-1].To,
+		Hops:      result.Redirects,
+		Permanent: true,
+	}
+	for _, hop := range result.Redirects {
+		rr.redirects.Statuses[strconv.Itoa(hop.Status)]++
+		found.Permanent = found.Permanent && hop.Permanent()
+	}
+	if result.Err != nil {
+		found.Error = result.Err.Error()
+	}
+	if rr.inScope != nil && !rr.inScope(found.Final) {
+		found.LeavesScope = true
+		rr.redirects.LeavingScope = append(rr.redirects.LeavingScope, found.Url)
+	}
+	rr.redirects.Chains = append(rr.redirects.Chains, found)
+}
+
+// Finish returns the redirect chains as JSON, in order of url
+func (rr *RedirectsReporter) Finish() string {
+	sort.Slice(rr.redirects.Chains, func(i, j int) bool {
+		return rr.redirects.Chains[i].Url < rr.redirects.Chains[j].Url
+	})
+	sort.Strings(rr.redirects.LeavingScope)
+
+	result, err := json.Marshal(&rr.redirects)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return string(result)
+}
@@ -0,0 +1,166 @@
+package reporting
+
+import (
+	"sync"
+	"tjweldon/spider/src/jobs"
+	"tjweldon/spider/src/links"
+	"tjweldon/spider/src/records"
+)
+
+// Reporter builds up a report from the events of a crawl. An EventBus calls
+// each Reporter's hooks from a goroutine of its own, one at a time, so they
+// don't need to lock anything.
+type Reporter interface {
+	// OnJobQueued is called with each job sent to the queue, including
+	// retries
+	OnJobQueued(job jobs.Job)
+
+	// OnPageFetched is called with the record of each page fetched, whether
+	// or not the fetch succeeded. The page's Error says why it failed, and
+	// its Result has the rest of what was fetched, during a crawl. It comes
+	// after OnLinkFound for each of the links on the page.
+	OnPageFetched(page records.Page)
+
+	// OnLinkFound is called with each link found on a page
+	OnLinkFound(link links.Link)
+
+	// OnError is called, after OnPageFetched, for each page that couldn't be
+	// fetched or crawled. The page's Error says why.
+	OnError(page records.Page)
+
+	// Finish is called once the crawl is over, and returns the report
+	Finish() string
+}
+
+// BaseReporter has a hook for every event that does nothing. Reporters embed
+// it so that they only implement the hooks they need.
+type BaseReporter struct{}
+
+// OnJobQueued is the implementation of Reporter.OnJobQueued
+func (BaseReporter) OnJobQueued(jobs.Job) {}
+
+// OnPageFetched is the implementation of Reporter.OnPageFetched
+func (BaseReporter) OnPageFetched(records.Page) {}
+
+// OnLinkFound is the implementation of Reporter.OnLinkFound
+func (BaseReporter) OnLinkFound(links.Link) {}
+
+// OnError is the implementation of Reporter.OnError
+func (BaseReporter) OnError(records.Page) {}
+
+// EventBus fans the events of a crawl out to the Reporters subscribed to it.
+// Publishing an event never blocks: each Reporter has a queue of events of
+// its own, with no limit, that a goroutine works through. A slow Reporter
+// falls behind without holding up the crawl or the other Reporters.
+type EventBus struct {
+	subscribers []*subscriber
+}
+
+// subscriber is a Reporter and its queue of events
+type subscriber struct {
+	reporter Reporter
+
+	mutex  sync.Mutex
+	events []func(Reporter)
+	closed bool
+	wake   chan Signal
+}
+
+// Signal is an empty message used to wake a waiting goroutine
+type Signal struct{}
+
+// NewEventBus returns an EventBus with no subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe starts passing events to the Reporter, and returns the channel
+// its report is sent on once the bus is closed. Reporters must all be
+// subscribed before anything is published.
+func (eb *EventBus) Subscribe(reporter Reporter) <-chan string {
+	sub := &subscriber{reporter: reporter, wake: make(chan Signal, 1)}
+	eb.subscribers = append(eb.subscribers, sub)
+
+	output := make(chan string)
+	go sub.run(output)
+	return output
+}
+
+// Subscribers returns the number of Reporters subscribed
+func (eb *EventBus) Subscribers() int {
+	return len(eb.subscribers)
+}
+
+// JobQueued publishes the OnJobQueued event
+func (eb *EventBus) JobQueued(job jobs.Job) {
+	eb.publish(func(r Reporter) { r.OnJobQueued(job) })
+}
+
+// PageFetched publishes the OnPageFetched event, followed by OnError if the
+// page has an error.
+func (eb *EventBus) PageFetched(page records.Page) {
+	eb.publish(func(r Reporter) { r.OnPageFetched(page) })
+	if page.Error != "" {
+		eb.publish(func(r Reporter) { r.OnError(page) })
+	}
+}
+
+// LinkFound publishes the OnLinkFound event
+func (eb *EventBus) LinkFound(link links.Link) {
+	eb.publish(func(r Reporter) { r.OnLinkFound(link) })
+}
+
+// Close tells the Reporters that the crawl is over. Each one finishes its
+// report once it has caught up with the events published before.
+func (eb *EventBus) Close() {
+	for _, sub := range eb.subscribers {
+		sub.mutex.Lock()
+		sub.closed = true
+		sub.mutex.Unlock()
+		sub.notify()
+	}
+}
+
+// publish adds the event to the queue of every subscriber
+func (eb *EventBus) publish(event func(Reporter)) {
+	for _, sub := range eb.subscribers {
+		sub.mutex.Lock()
+		if !sub.closed {
+			sub.events = append(sub.events, event)
+		}
+		sub.mutex.Unlock()
+		sub.notify()
+	}
+}
+
+// notify wakes the subscriber's goroutine without blocking
+func (s *subscriber) notify() {
+	select {
+	case s.wake <- Signal{}:
+	default:
+	}
+}
+
+// run passes the events to the Reporter as they come in, and sends its
+// report once the bus is closed and every event has been handled.
+func (s *subscriber) run(output chan<- string) {
+	defer close(output)
+	for {
+		s.mutex.Lock()
+		events, closed := s.events, s.closed
+		s.events = nil
+		s.mutex.Unlock()
+
+		for _, event := range events {
+			event(s.reporter)
+		}
+		if len(events) > 0 {
+			continue
+		}
+		if closed {
+			output <- s.reporter.Finish()
+			return
+		}
+		<-s.wake
+	}
+}
@@ -0,0 +1,121 @@
+package reporting
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"tjweldon/spider/src/jobs"
+	"tjweldon/spider/src/links"
+	"tjweldon/spider/src/records"
+)
+
+// eventLog is a Reporter whose report is the events it was given, in order.
+// If block is set, each OnJobQueued waits for it to be closed.
+type eventLog struct {
+	events []string
+	block  chan Signal
+}
+
+func (el *eventLog) OnJobQueued(job jobs.Job) {
+	if el.block != nil {
+		<-el.block
+	}
+	el.events = append(el.events, "queued "+job.Url)
+}
+
+func (el *eventLog) OnPageFetched(page records.Page) {
+	el.events = append(el.events, "fetched "+page.Url)
+}
+
+func (el *eventLog) OnLinkFound(link links.Link) {
+	el.events = append(el.events, "link "+link.Target)
+}
+
+func (el *eventLog) OnError(page records.Page) {
+	el.events = append(el.events, "error "+page.Url)
+}
+
+func (el *eventLog) Finish() string {
+	return strings.Join(el.events, ", ")
+}
+
+// awaitReport returns the report sent on the channel, failing the test if it
+// doesn't come in time
+func awaitReport(t *testing.T, report <-chan string) string {
+	t.Helper()
+	select {
+	case finished := <-report:
+		return finished
+	case <-time.After(5 * time.Second):
+		t.Fatal("the report never came")
+		return ""
+	}
+}
+
+func TestEventBusFansOutInOrder(t *testing.T) {
+	bus := NewEventBus()
+	reports := []<-chan string{bus.Subscribe(&eventLog{}), bus.Subscribe(&eventLog{})}
+
+	bus.JobQueued(jobs.Job{Url: "http://a.test/"})
+	bus.LinkFound(links.Link{Source: "http://a.test/", Target: "http://a.test/x"})
+	bus.PageFetched(records.Page{Url: "http://a.test/"})
+	bus.JobQueued(jobs.Job{Url: "http://a.test/x"})
+	bus.PageFetched(records.Page{Url: "http://a.test/x", Error: "404 Not Found"})
+	bus.Close()
+
+	want := "queued http://a.test/, link http://a.test/x, fetched http://a.test/, " +
+		"queued http://a.test/x, fetched http://a.test/x, error http://a.test/x"
+	for i, report := range reports {
+		if got := awaitReport(t, report); got != want {
+			t.Errorf("subscriber %d got %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestEventBusDoesntWaitForSlowSubscribers(t *testing.T) {
+	slow := &eventLog{block: make(chan Signal)}
+	bus := NewEventBus()
+	slowReport := bus.Subscribe(slow)
+	fastReport := bus.Subscribe(&eventLog{})
+
+	published := make(chan Signal)
+	go func() {
+		for i := 0; i < 10000; i++ {
+			bus.JobQueued(jobs.Job{Url: fmt.Sprintf("http://a.test/%d", i)})
+		}
+		bus.Close()
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishing was held up by the slow subscriber")
+	}
+
+	if got := strings.Count(awaitReport(t, fastReport), "queued"); got != 10000 {
+		t.Errorf("the fast subscriber got %d events, want 10000", got)
+	}
+	close(slow.block)
+	if got := strings.Count(awaitReport(t, slowReport), "queued"); got != 10000 {
+		t.Errorf("the slow subscriber got %d events, want 10000", got)
+	}
+}
+
+func TestEventBusFinishesOnlyOnceClosed(t *testing.T) {
+	bus := NewEventBus()
+	report := bus.Subscribe(&eventLog{})
+
+	bus.JobQueued(jobs.Job{Url: "http://a.test/"})
+	select {
+	case finished := <-report:
+		t.Fatalf("the report %q came before the bus was closed", finished)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	bus.Close()
+	bus.JobQueued(jobs.Job{Url: "http://a.test/late"})
+	if got := awaitReport(t, report); got != "queued http://a.test/" {
+		t.Errorf("report = %q, want just the event published before closing", got)
+	}
+}
@@ -0,0 +1,193 @@
+package reporting
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"tjweldon/spider/src/fetch"
+	"tjweldon/spider/src/messaging"
+	"tjweldon/spider/src/records"
+	"tjweldon/spider/src/sitemap"
+	"tjweldon/spider/src/swarm"
+	"tjweldon/spider/src/util"
+)
+
+// SitemapWriter saves one of the sitemaps listed by a sitemap index, under
+// the file name that the index gives for it
+type SitemapWriter func(name string, document []byte) error
+
+// SitemapSettings control how SitemapReporter writes the sitemap
+type SitemapSettings struct {
+	// LastMod includes the Last-Modified date of each page that has one
+	LastMod bool
+
+	// MaxUrls is the most urls listed in one sitemap, sitemap.MaxUrls if
+	// zero. Beyond that the sitemap is split into several, which are saved
+	// by Write, and the report is a sitemap index listing them at Location.
+	MaxUrls  int
+	Location string
+	Write    SitemapWriter
+
+	// Canonicalise, if set, is applied to a page's url and to the canonical
+	// url it gives before they are compared, so that a page isn't taken to
+	// point elsewhere for writing its own url differently
+	Canonicalise messaging.PreProcessor[string]
+}
+
+// SitemapReporter writes a sitemap.xml listing the HTML pages that were
+// fetched with a 200, by the url they were retrieved from. Pages that were
+// redirected out of inScope are left out, as are those that ask not to be
+// indexed, or that give a different page as their canonical url.
+type SitemapReporter struct {
+	BaseReporter
+	inScope  messaging.Validator[string]
+	settings SitemapSettings
+	pages    map[string]string
+}
+
+// NewSitemapReporter returns a SitemapReporter with no pages yet
+func NewSitemapReporter(inScope messaging.Validator[string], settings SitemapSettings) *SitemapReporter {
+	return &SitemapReporter{inScope: inScope, settings: settings, pages: map[string]string{}}
+}
+
+// OnPageFetched adds the page to the sitemap if it belongs there
+func (sr *SitemapReporter) OnPageFetched(record records.Page) {
+	result := record.Result
+	if result == nil || !result.Ok() || result.Status != http.StatusOK || !swarm.IsHtml(result.MediaType()) {
+		return
+	}
+	page := result.FinalUrl.String()
+	if sr.inScope != nil && !sr.inScope(page) {
+		return
+	}
+	if noIndex, canonical := indexing(result); noIndex || canonical != "" && !sr.settings.sameUrl(canonical, page) {
+		return
+	}
+	sr.pages[page] = ""
+	if sr.settings.LastMod {
+		if modified, err := http.ParseTime(result.Header.Get("Last-Modified")); err == nil {
+			sr.pages[page] = sitemap.LastMod(modified)
+		}
+	}
+}
+
+// Finish returns the sitemap, or the index of the sitemaps it was split into
+func (sr *SitemapReporter) Finish() string {
+	var urls []sitemap.Entry
+	for page, lastMod := range sr.pages {
+		urls = append(urls, sitemap.Entry{Loc: page, LastMod: lastMod})
+	}
+	sort.Slice(urls, func(i, j int) bool {
+		return urls[i].Loc < urls[j].Loc
+	})
+
+	result, err := writeSitemaps(urls, sr.settings)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return string(result)
+}
+
+// sameUrl is true if the urls are the same once canonicalised
+func (s SitemapSettings) sameUrl(a, b string) bool {
+	if s.Canonicalise != nil {
+		a, b = s.Canonicalise(a), s.Canonicalise(b)
+	}
+	return a == b
+}
+
+// writeSitemaps returns the sitemap of the urls, or if they don't fit in one,
+// saves each part and returns the index of them.
+func writeSitemaps(urls []sitemap.Entry, settings SitemapSettings) ([]byte, error) {
+	parts := sitemap.Split(urls, settings.MaxUrls)
+	if len(parts) == 1 {
+		return (&sitemap.Document{Urls: parts[0]}).Marshal()
+	}
+	if settings.Write == nil {
+		return nil, fmt.Errorf("sitemap of %d urls needs splitting, but there's nowhere to write the parts", len(urls))
+	}
+
+	index := &sitemap.Document{}
+	for i, part := range parts {
+		name := fmt.Sprintf("sitemap-%d.xml", i+1)
+		document, err := (&sitemap.Document{Urls: part}).Marshal()
+		if err != nil {
+			return nil, err
+		}
+		if err := settings.Write(name, document); err != nil {
+			return nil, err
+		}
+		index.Sitemaps = append(index.Sitemaps, sitemap.Entry{Loc: settings.Location + name})
+	}
+	return index.Marshal()
+}
+
+// indexing reads a page's X-Robots-Tag headers and the <meta name="robots">
+// and <link rel="canonical"> in its <head>, to find out whether it asks not
+// to be indexed and which url it says is the canonical one, if any.
+func indexing(result *fetch.Result) (noIndex bool, canonical string) {
+	for _, value := range result.Header.Values("X-Robots-Tag") {
+		noIndex = noIndex || hasNoIndex(value)
+	}
+
+	base := result.FinalUrl
+	tokenizer := html.NewTokenizer(bytes.NewReader(result.Body))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return noIndex, canonical
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.DataAtom {
+			case atom.Body:
+				return noIndex, canonical
+			case atom.Base:
+				if resolved, err := base.Parse(attr(token, "href")); err == nil {
+					base = resolved
+				}
+			case atom.Meta:
+				if strings.EqualFold(attr(token, "name"), "robots") {
+					noIndex = noIndex || hasNoIndex(attr(token, "content"))
+				}
+			case atom.Link:
+				rel := strings.Fields(strings.ToLower(attr(token, "rel")))
+				if canonical == "" && util.Contains(rel, "canonical") {
+					if resolved, err := base.Parse(strings.TrimSpace(attr(token, "href"))); err == nil {
+						resolved.Fragment = ""
+						canonical = resolved.String()
+					}
+				}
+			}
+		}
+	}
+}
+
+// hasNoIndex is true if a list of robots directives, such as "noindex,
+// nofollow" or "googlebot: none", includes noindex or none
+func hasNoIndex(directives string) bool {
+	for _, directive := range strings.Split(directives, ",") {
+		if i := strings.LastIndex(directive, ":"); i >= 0 {
+			directive = directive[i+1:]
+		}
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "noindex", "none":
+			return true
+		}
+	}
+	return false
+}
+
+// attr returns the value of a token's attribute, or "" if it hasn't got it
+func attr(token html.Token, key string) string {
+	for _, a := range token.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
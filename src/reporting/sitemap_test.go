@@ -0,0 +1,51 @@
+package reporting
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"tjweldon/spider/src/fetch"
+	"tjweldon/spider/src/records"
+	"tjweldon/spider/src/urls"
+)
+
+// htmlResult is a successful fetch of an HTML page with the canonical url
+func htmlResult(t *testing.T, pageUrl, canonical string) *fetch.Result {
+	t.Helper()
+	finalUrl, err := url.Parse(pageUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "text/html")
+	body := fmt.Sprintf(`<html><head><link rel="canonical" href="%s"></head><body></body></html>`, canonical)
+	return &fetch.Result{Url: pageUrl, FinalUrl: finalUrl, Status: http.StatusOK, Header: header, Body: []byte(body)}
+}
+
+func TestSitemapReportCanonicalisesBeforeComparing(t *testing.T) {
+	results := []*fetch.Result{
+		htmlResult(t, "http://example.com/", "HTTP://Example.com:80"),
+		htmlResult(t, "http://example.com/list?a=1&b=2", "http://example.com/list?b=2&a=1"),
+		htmlResult(t, "http://example.com/folded", "http://example.com/folded/"),
+		htmlResult(t, "http://example.com/copy", "http://example.com/original"),
+	}
+	canonicaliser := urls.NewCanonicaliser().SetFoldTrailingSlash(true)
+	reporter := NewSitemapReporter(nil, SitemapSettings{Canonicalise: canonicaliser.PreProcessor()})
+	for _, result := range results {
+		reporter.OnPageFetched(records.Page{Url: result.Url, Result: result})
+	}
+	sitemap := reporter.Finish()
+
+	for _, listed := range []string{
+		"http://example.com/", "http://example.com/list?a=1&amp;b=2", "http://example.com/folded",
+	} {
+		if !strings.Contains(sitemap, "<loc>"+listed+"</loc>") {
+			t.Errorf("%s is missing from the sitemap:\n%s", listed, sitemap)
+		}
+	}
+	if strings.Contains(sitemap, "/copy") {
+		t.Errorf("a page with another page as its canonical is in the sitemap:\n%s", sitemap)
+	}
+}
@@ -0,0 +1,87 @@
+package reporting
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"tjweldon/spider/src/links"
+	"tjweldon/spider/src/messaging"
+	"tjweldon/spider/src/records"
+	"tjweldon/spider/src/swarm"
+)
+
+// SitemapDiscrepancyReporter compares the pages listed in a site's sitemaps
+// with the pages that the crawl found links to. It lists the sitemap pages
+// that nothing links to, and the pages that are linked to and were fetched
+// successfully as HTML, but are missing from the sitemaps. Urls that
+// redirect don't belong in a sitemap, so only where they lead is expected
+// there. Pages that aren't inScope are ignored.
+type SitemapDiscrepancyReporter struct {
+	BaseReporter
+	inScope messaging.Validator[string]
+	listed  []string
+	linked  map[string]bool
+	pages   map[string]bool
+}
+
+// NewSitemapDiscrepancyReporter returns a SitemapDiscrepancyReporter with
+// nothing listed or found yet
+func NewSitemapDiscrepancyReporter(inScope messaging.Validator[string]) *SitemapDiscrepancyReporter {
+	return &SitemapDiscrepancyReporter{inScope: inScope, linked: map[string]bool{}, pages: map[string]bool{}}
+}
+
+// SetListed is a fluent setter for the pages listed in the sitemaps. They
+// are only needed by Finish, so they can be set once the reporter is
+// subscribed, as long as it is before the bus is closed.
+func (sr *SitemapDiscrepancyReporter) SetListed(listed []string) *SitemapDiscrepancyReporter {
+	sr.listed = listed
+	return sr
+}
+
+// OnLinkFound marks the link's target as linked to
+func (sr *SitemapDiscrepancyReporter) OnLinkFound(link links.Link) {
+	sr.linked[link.Target] = true
+}
+
+// OnPageFetched keeps track of the pages that belong in a sitemap
+func (sr *SitemapDiscrepancyReporter) OnPageFetched(page records.Page) {
+	result := page.Result
+	if result != nil && result.Ok() && !result.Redirected() && swarm.IsHtml(result.MediaType()) {
+		sr.pages[result.Url] = true
+	}
+}
+
+// Finish returns the discrepancies as JSON
+func (sr *SitemapDiscrepancyReporter) Finish() string {
+	type report struct {
+		Listed   int      `json:"listed"`
+		Unlinked []string `json:"unlinked"`
+		Missing  []string `json:"missing"`
+	}
+
+	inSitemap := map[string]bool{}
+	discrepancies := report{Unlinked: []string{}, Missing: []string{}}
+	for _, page := range sr.listed {
+		if sr.inScope != nil && !sr.inScope(page) || inSitemap[page] {
+			continue
+		}
+		inSitemap[page] = true
+		if !sr.linked[page] {
+			discrepancies.Unlinked = append(discrepancies.Unlinked, page)
+		}
+	}
+	for page := range sr.pages {
+		if sr.linked[page] && !inSitemap[page] && (sr.inScope == nil || sr.inScope(page)) {
+			discrepancies.Missing = append(discrepancies.Missing, page)
+		}
+	}
+	discrepancies.Listed = len(inSitemap)
+	sort.Strings(discrepancies.Unlinked)
+	sort.Strings(discrepancies.Missing)
+
+	result, err := json.Marshal(&discrepancies)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return string(result)
+}
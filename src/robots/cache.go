@@ -0,0 +1,164 @@
+package robots
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+	"tjweldon/spider/src/messaging"
+)
+
+// maxRobotsSize is the most of a robots.txt file that will be read. RFC 9309
+// requires crawlers to parse at least the first 500 KiB.
+const maxRobotsSize = 500 * 1024
+
+// Cache fetches the robots.txt for each host the first time it is needed and
+// keeps it for the lifetime of the crawl. It is safe for concurrent use.
+type Cache struct {
+	userAgent string
+	client    *http.Client
+	mutex     sync.Mutex
+	hosts     map[string]*hostEntry
+}
+
+// hostEntry holds the robots.txt for a single scheme and host, along with the
+// time at which the next request to that host may be made.
+type hostEntry struct {
+	once   sync.Once
+	robots *Robots
+	mutex  sync.Mutex
+	next   time.Time
+}
+
+// NewCache returns an empty Cache that will identify itself with the passed
+// user agent when fetching and matching robots.txt groups.
+func NewCache(userAgent string) *Cache {
+	return &Cache{
+		userAgent: userAgent,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		hosts:     map[string]*hostEntry{},
+	}
+}
+
+// SetClient is a fluent setter for the http client used to fetch robots.txt
+func (c *Cache) SetClient(client *http.Client) *Cache {
+	c.client = client
+	return c
+}
+
+// Get returns the Robots that apply to the target url, fetching them if this
+// is the first url seen for its host.
+func (c *Cache) Get(target string) *Robots {
+	parsed, err := url.Parse(target)
+	if err != nil || parsed.Host == "" {
+		return AllowAll()
+	}
+	entry := c.entry(parsed)
+	entry.once.Do(func() {
+		entry.robots = c.fetch(parsed)
+	})
+	return entry.robots
+}
+
+// Allowed reports whether robots.txt permits fetching the target url.
+func (c *Cache) Allowed(target string) bool {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	path := parsed.EscapedPath()
+	if parsed.RawQuery != "" {
+		path += "?" + parsed.RawQuery
+	}
+	return c.Get(target).Allowed(c.userAgent, path)
+}
+
+// CrawlDelay returns the Crawl-delay that applies to the target's host.
+func (c *Cache) CrawlDelay(target string) time.Duration {
+	return c.Get(target).CrawlDelay(c.userAgent)
+}
+
+// Wait blocks until the Crawl-delay for the target's host has elapsed since
+// the last request made to it. Concurrent callers are given consecutive slots
+// so the delay holds across all the crawlers sharing the Cache. It returns
+// early with the context's error if the context is cancelled.
+func (c *Cache) Wait(ctx context.Context, target string) error {
+	parsed, err := url.Parse(target)
+	if err != nil || parsed.Host == "" {
+		return nil
+	}
+	delay := c.CrawlDelay(target)
+	if delay <= 0 {
+		return nil
+	}
+
+	entry := c.entry(parsed)
+	entry.mutex.Lock()
+	now := time.Now()
+	slot := entry.next
+	if slot.Before(now) {
+		slot = now
+	}
+	entry.next = slot.Add(delay)
+	entry.mutex.Unlock()
+
+	timer := time.NewTimer(slot.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Validator returns a messaging.Validator that filters out the urls that
+// robots.txt disallows, so that it can be chained with
+// messaging.WithValidation.
+func (c *Cache) Validator() messaging.Validator[string] {
+	return c.Allowed
+}
+
+// entry returns the hostEntry for the url's scheme and host, creating it if
+// necessary.
+func (c *Cache) entry(parsed *url.URL) *hostEntry {
+	key := parsed.Scheme + "://" + parsed.Host
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.hosts[key]
+	if !ok {
+		entry = &hostEntry{}
+		c.hosts[key] = entry
+	}
+	return entry
+}
+
+// fetch retrieves and parses robots.txt for the url's host. A missing file
+// means everything is allowed, while a server error or an unreachable host
+// means nothing is.
+func (c *Cache) fetch(parsed *url.URL) *Robots {
+	robotsUrl := url.URL{Scheme: parsed.Scheme, Host: parsed.Host, Path: "/robots.txt"}
+	req, err := http.NewRequest(http.MethodGet, robotsUrl.String(), nil)
+	if err != nil {
+		return DisallowAll()
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return DisallowAll()
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return Parse(io.LimitReader(resp.Body, maxRobotsSize))
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return AllowAll()
+	default:
+		return DisallowAll()
+	}
+}
@@ -0,0 +1,232 @@
+package robots
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Robots is the parsed form of a robots.txt file. It holds the groups of
+// rules keyed by user agent and any sitemaps the file advertises.
+type Robots struct {
+	Groups   []*Group
+	Sitemaps []string
+}
+
+// Group is a set of rules that apply to the user agents that head it.
+type Group struct {
+	Agents     []string
+	Rules      []Rule
+	CrawlDelay time.Duration
+}
+
+// Rule is a single Allow or Disallow line. Patterns may contain the *
+// wildcard and may be anchored to the end of the path with a trailing $.
+type Rule struct {
+	Allow   bool
+	Pattern string
+	matcher *regexp.Regexp
+}
+
+// AllowAll returns Robots that places no restrictions on the crawl. This is
+// what is used when a site has no robots.txt.
+func AllowAll() *Robots {
+	return &Robots{}
+}
+
+// DisallowAll returns Robots that forbids crawling anything. This is what is
+// used when the robots.txt for a site cannot be retrieved.
+func DisallowAll() *Robots {
+	return &Robots{
+		Groups: []*Group{
+			{Agents: []string{"*"}, Rules: []Rule{NewRule(false, "/")}},
+		},
+	}
+}
+
+// Parse reads a robots.txt file. Lines that can't be understood are skipped
+// rather than failing the whole file, as recommended by RFC 9309.
+func Parse(r io.Reader) *Robots {
+	robots := &Robots{}
+	var (
+		current     *Group
+		inRuleBlock bool
+		scanner     = bufio.NewScanner(r)
+	)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// Consecutive user-agent lines share a group, a user-agent line
+			// after any rules starts a new one.
+			if current == nil || inRuleBlock {
+				current = &Group{}
+				robots.Groups = append(robots.Groups, current)
+				inRuleBlock = false
+			}
+			current.Agents = append(current.Agents, strings.ToLower(value))
+		case "allow", "disallow":
+			if current == nil {
+				continue
+			}
+			inRuleBlock = true
+			// An empty disallow means nothing is disallowed
+			if value == "" {
+				continue
+			}
+			current.Rules = append(current.Rules, NewRule(key == "allow", value))
+		case "crawl-delay":
+			if current == nil {
+				continue
+			}
+			inRuleBlock = true
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil || seconds < 0 {
+				continue
+			}
+			current.CrawlDelay = time.Duration(seconds * float64(time.Second))
+		case "sitemap":
+			if value != "" {
+				robots.Sitemaps = append(robots.Sitemaps, value)
+			}
+		}
+	}
+
+	return robots
+}
+
+// Group returns the rules that apply to the passed user agent. Groups naming
+// the agent's product token take precedence over the * group, and all the
+// groups that match are merged. Returns nil if no group applies.
+func (r *Robots) Group(userAgent string) *Group {
+	token := ProductToken(userAgent)
+
+	var named, wildcard []*Group
+	for _, group := range r.Groups {
+		// A group may name the agent after *, so every agent has to be
+		// checked before settling for the wildcard
+		isNamed, isWildcard := false, false
+		for _, agent := range group.Agents {
+			isNamed = isNamed || agent == token
+			isWildcard = isWildcard || agent == "*"
+		}
+		if isNamed {
+			named = append(named, group)
+		} else if isWildcard {
+			wildcard = append(wildcard, group)
+		}
+	}
+
+	matched := named
+	if len(matched) == 0 {
+		matched = wildcard
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+	if len(matched) == 1 {
+		return matched[0]
+	}
+
+	merged := &Group{}
+	for _, group := range matched {
+		merged.Agents = append(merged.Agents, group.Agents...)
+		merged.Rules = append(merged.Rules, group.Rules...)
+		if group.CrawlDelay > merged.CrawlDelay {
+			merged.CrawlDelay = group.CrawlDelay
+		}
+	}
+	return merged
+}
+
+// Allowed reports whether the user agent may fetch the passed path. The path
+// should include the query string if there is one.
+func (r *Robots) Allowed(userAgent, path string) bool {
+	group := r.Group(userAgent)
+	if group == nil {
+		return true
+	}
+	return group.Allowed(path)
+}
+
+// CrawlDelay returns the delay the user agent should leave between requests.
+func (r *Robots) CrawlDelay(userAgent string) time.Duration {
+	group := r.Group(userAgent)
+	if group == nil {
+		return 0
+	}
+	return group.CrawlDelay
+}
+
+// Allowed applies the longest matching rule to the path. When an Allow and a
+// Disallow rule match with the same length, the Allow wins.
+func (g *Group) Allowed(path string) bool {
+	if path == "" {
+		path = "/"
+	}
+	if path == "/robots.txt" {
+		return true
+	}
+
+	allowed, longest := true, -1
+	for _, rule := range g.Rules {
+		if !rule.Matches(path) {
+			continue
+		}
+		length := len(rule.Pattern)
+		if length > longest || (length == longest && rule.Allow) {
+			allowed, longest = rule.Allow, length
+		}
+	}
+	return allowed
+}
+
+// NewRule compiles the pattern of an Allow or Disallow line.
+func NewRule(allow bool, pattern string) Rule {
+	anchored := strings.HasSuffix(pattern, "$")
+	expr := strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(expr, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	expr = "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+
+	return Rule{
+		Allow:   allow,
+		Pattern: pattern,
+		matcher: regexp.MustCompile(expr),
+	}
+}
+
+// Matches returns true if the rule applies to the path.
+func (r Rule) Matches(path string) bool {
+	return r.matcher.MatchString(path)
+}
+
+// ProductToken reduces a full user agent string such as "spider/1.0" to the
+// lowercase token robots.txt groups are matched against.
+func ProductToken(userAgent string) string {
+	token, _, _ := strings.Cut(userAgent, "/")
+	if fields := strings.Fields(token); len(fields) > 0 {
+		token = fields[0]
+	}
+	return strings.ToLower(token)
+}
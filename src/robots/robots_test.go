@@ -0,0 +1,229 @@
+package robots
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// serveRobots starts a server that answers /robots.txt with the status and
+// body, and every other path with an empty page.
+func serveRobots(t *testing.T, status int, body string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/robots.txt" {
+			return
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCacheAllowed(t *testing.T) {
+	type check struct {
+		agent   string
+		path    string
+		allowed bool
+	}
+	cases := []struct {
+		name   string
+		status int
+		robots string
+		checks []check
+	}{
+		{
+			name:   "named agent takes precedence over wildcard",
+			status: http.StatusOK,
+			robots: "User-agent: *\nDisallow: /\n\nUser-agent: spider\nDisallow: /private\n",
+			checks: []check{
+				{"spider/1.0", "/other", true},
+				{"spider/1.0", "/private/page", false},
+				{"other-bot", "/other", false},
+			},
+		},
+		{
+			name:   "agent named after wildcard in the same group",
+			status: http.StatusOK,
+			robots: "User-agent: *\nUser-agent: spider\nDisallow: /private\n\nUser-agent: *\nDisallow: /\n",
+			checks: []check{
+				{"spider", "/other", true},
+				{"spider", "/private", false},
+				{"other-bot", "/other", false},
+			},
+		},
+		{
+			name:   "agent matching ignores case and version",
+			status: http.StatusOK,
+			robots: "User-agent: Spider\nDisallow: /\n",
+			checks: []check{
+				{"SPIDER/2.1 (+https://example.com)", "/page", false},
+				{"spiderling", "/page", true},
+			},
+		},
+		{
+			name:   "groups for the same agent are merged",
+			status: http.StatusOK,
+			robots: "User-agent: spider\nDisallow: /a\n\nUser-agent: other\nDisallow: /\n\nUser-agent: spider\nDisallow: /b\n",
+			checks: []check{
+				{"spider", "/a", false},
+				{"spider", "/b", false},
+				{"spider", "/c", true},
+			},
+		},
+		{
+			name:   "longest match wins",
+			status: http.StatusOK,
+			robots: "User-agent: *\nDisallow: /shop\nAllow: /shop/public\nDisallow: /shop/public/secret\n",
+			checks: []check{
+				{"spider", "/shop/cart", false},
+				{"spider", "/shop/public/item", true},
+				{"spider", "/shop/public/secret", false},
+				{"spider", "/about", true},
+			},
+		},
+		{
+			name:   "allow wins a tie",
+			status: http.StatusOK,
+			robots: "User-agent: *\nDisallow: /page\nAllow: /page\n",
+			checks: []check{
+				{"spider", "/page", true},
+			},
+		},
+		{
+			name:   "wildcards and end anchors",
+			status: http.StatusOK,
+			robots: "User-agent: *\nDisallow: /*.pdf$\nDisallow: /*?session=\nDisallow: /exact$\n",
+			checks: []check{
+				{"spider", "/docs/file.pdf", false},
+				{"spider", "/docs/file.pdf?x=1", true},
+				{"spider", "/list?session=abc", false},
+				{"spider", "/list?page=2", true},
+				{"spider", "/exact", false},
+				{"spider", "/exact/more", true},
+			},
+		},
+		{
+			name:   "empty disallow allows everything",
+			status: http.StatusOK,
+			robots: "User-agent: *\nDisallow:\n",
+			checks: []check{
+				{"spider", "/anything", true},
+			},
+		},
+		{
+			name:   "robots.txt is always allowed",
+			status: http.StatusOK,
+			robots: "User-agent: *\nDisallow: /\n",
+			checks: []check{
+				{"spider", "/robots.txt", true},
+				{"spider", "/", false},
+			},
+		},
+		{
+			name:   "missing robots.txt allows everything",
+			status: http.StatusNotFound,
+			checks: []check{
+				{"spider", "/private", true},
+			},
+		},
+		{
+			name:   "forbidden robots.txt allows everything",
+			status: http.StatusForbidden,
+			robots: "User-agent: *\nDisallow: /\n",
+			checks: []check{
+				{"spider", "/private", true},
+			},
+		},
+		{
+			name:   "server error disallows everything",
+			status: http.StatusServiceUnavailable,
+			checks: []check{
+				{"spider", "/", false},
+				{"spider", "/page", false},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			server := serveRobots(t, c.status, c.robots)
+			for _, check := range c.checks {
+				cache := NewCache(check.agent)
+				if got := cache.Allowed(server.URL + check.path); got != check.allowed {
+					t.Errorf("Allowed(%q, %q) = %v, want %v", check.agent, check.path, got, check.allowed)
+				}
+			}
+		})
+	}
+}
+
+func TestUnreachableRobotsDisallowsEverything(t *testing.T) {
+	server := serveRobots(t, http.StatusOK, "")
+	target := server.URL + "/page"
+	server.Close()
+
+	if NewCache("spider").Allowed(target) {
+		t.Errorf("Allowed(%q) = true for an unreachable host, want false", target)
+	}
+}
+
+func TestCrawlDelay(t *testing.T) {
+	cases := []struct {
+		name   string
+		agent  string
+		robots string
+		delay  time.Duration
+	}{
+		{"whole seconds", "spider", "User-agent: *\nCrawl-delay: 2\n", 2 * time.Second},
+		{"fractional seconds", "spider", "User-agent: *\nCrawl-delay: 0.5\n", 500 * time.Millisecond},
+		{"named group", "spider", "User-agent: *\nCrawl-delay: 9\n\nUser-agent: spider\nCrawl-delay: 1\n", time.Second},
+		{"largest of merged groups", "spider", "User-agent: spider\nCrawl-delay: 1\n\nUser-agent: spider\nCrawl-delay: 3\n", 3 * time.Second},
+		{"invalid", "spider", "User-agent: *\nCrawl-delay: soon\n", 0},
+		{"negative", "spider", "User-agent: *\nCrawl-delay: -1\n", 0},
+		{"none", "spider", "User-agent: *\nDisallow: /private\n", 0},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			server := serveRobots(t, http.StatusOK, c.robots)
+			if got := NewCache(c.agent).CrawlDelay(server.URL + "/"); got != c.delay {
+				t.Errorf("CrawlDelay() = %v, want %v", got, c.delay)
+			}
+		})
+	}
+}
+
+func TestWaitSpacesRequests(t *testing.T) {
+	server := serveRobots(t, http.StatusOK, "User-agent: *\nCrawl-delay: 0.05\n")
+	cache := NewCache("spider")
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := cache.Wait(context.Background(), server.URL+"/"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("3 requests took %v, want at least 100ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := cache.Wait(ctx, server.URL+"/"); err != context.Canceled {
+		t.Errorf("Wait() with a cancelled context = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestParseSitemaps(t *testing.T) {
+	server := serveRobots(t, http.StatusOK, "Sitemap: https://example.com/a.xml\nUser-agent: *\nDisallow: /\nSitemap: https://example.com/b.xml\n")
+	sitemaps := NewCache("spider").Get(server.URL + "/").Sitemaps
+	if len(sitemaps) != 2 || sitemaps[0] != "https://example.com/a.xml" || sitemaps[1] != "https://example.com/b.xml" {
+		t.Errorf("Sitemaps = %v", sitemaps)
+	}
+}
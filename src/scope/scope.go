@@ -0,0 +1,338 @@
+package scope
+
+import (
+	"fmt"
+	"golang.org/x/net/publicsuffix"
+	"net/url"
+	"regexp"
+	"strings"
+	"tjweldon/spider/src/messaging"
+)
+
+// Mode is how far from its seeds a crawl is allowed to wander
+type Mode string
+
+const (
+	// Any places no restriction on hosts
+	Any Mode = "any"
+
+	// Host keeps the crawl on exactly the hosts of the seeds
+	Host Mode = "host"
+
+	// Domain keeps the crawl on the registrable domains of the seeds and all
+	// of their subdomains, so www.example.com and blog.example.com are both
+	// in scope for a seed of example.com
+	Domain Mode = "domain"
+
+	// Subdomain keeps the crawl on the hosts of the seeds and subdomains of
+	// them, but not their parent or sibling domains
+	Subdomain Mode = "subdomain"
+
+	// Prefix keeps the crawl on the hosts of the seeds and under the
+	// directory of each seed's path
+	Prefix Mode = "prefix"
+)
+
+// Modes lists every Mode, for help text and error messages
+var Modes = []Mode{Any, Host, Domain, Subdomain, Prefix}
+
+// ParseMode returns the Mode with the passed name
+func ParseMode(name string) (Mode, error) {
+	for _, mode := range Modes {
+		if string(mode) == strings.ToLower(name) {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unknown scope %q, expected one of %v", name, Modes)
+}
+
+// Scope combines a Mode with explicit host lists and url patterns. A url is
+// in scope if it is within the Mode of one of the seeds or on an allowed
+// host, it is not on a denied host, it matches an include pattern if there
+// are any, and it matches none of the exclude patterns.
+type Scope struct {
+	mode    messaging.Validator[string]
+	allow   messaging.Validator[string]
+	deny    messaging.Validator[string]
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// New creates a Scope of the passed Mode around the seed urls
+func New(mode Mode, seeds ...string) (*Scope, error) {
+	validator, err := ForMode(mode, seeds...)
+	if err != nil {
+		return nil, err
+	}
+	return &Scope{mode: validator}, nil
+}
+
+// ForSeeds creates a Scope around seeds that each have their own Mode. A url
+// is within the Mode part of the Scope if it is within the Mode of any one of
+// the seeds.
+func ForSeeds(seeds ...Seed) (*Scope, error) {
+	byMode := map[Mode][]string{}
+	for _, seed := range seeds {
+		byMode[seed.Mode] = append(byMode[seed.Mode], seed.Url)
+	}
+
+	validators := make([]messaging.Validator[string], 0, len(byMode))
+	for _, mode := range Modes {
+		if urls, ok := byMode[mode]; ok {
+			validator, err := ForMode(mode, urls...)
+			if err != nil {
+				return nil, err
+			}
+			validators = append(validators, validator)
+			delete(byMode, mode)
+		}
+	}
+	for mode := range byMode {
+		return nil, fmt.Errorf("unknown scope %q, expected one of %v", mode, Modes)
+	}
+
+	return &Scope{mode: func(rawUrl string) bool {
+		for _, validator := range validators {
+			if validator(rawUrl) {
+				return true
+			}
+		}
+		return false
+	}}, nil
+}
+
+// AllowHosts is a fluent setter for hosts that are in scope regardless of
+// the Mode. Subdomains of the hosts are allowed too.
+func (s *Scope) AllowHosts(hosts ...string) *Scope {
+	if len(hosts) > 0 {
+		s.allow = OnHosts(hosts...)
+	}
+	return s
+}
+
+// DenyHosts is a fluent setter for hosts that are never in scope. Subdomains
+// of the hosts are denied too.
+func (s *Scope) DenyHosts(hosts ...string) *Scope {
+	if len(hosts) > 0 {
+		s.deny = OnHosts(hosts...)
+	}
+	return s
+}
+
+// Include is a fluent setter for patterns, one of which urls must match
+func (s *Scope) Include(patterns ...*regexp.Regexp) *Scope {
+	s.include = append(s.include, patterns...)
+	return s
+}
+
+// Exclude is a fluent setter for patterns that urls must not match
+func (s *Scope) Exclude(patterns ...*regexp.Regexp) *Scope {
+	s.exclude = append(s.exclude, patterns...)
+	return s
+}
+
+// Contains reports whether the url is in scope
+func (s *Scope) Contains(rawUrl string) bool {
+	if !s.mode(rawUrl) && (s.allow == nil || !s.allow(rawUrl)) {
+		return false
+	}
+	if s.deny != nil && s.deny(rawUrl) {
+		return false
+	}
+	if len(s.include) > 0 && !MatchesAny(s.include...)(rawUrl) {
+		return false
+	}
+	if len(s.exclude) > 0 && MatchesAny(s.exclude...)(rawUrl) {
+		return false
+	}
+	return true
+}
+
+// Validator returns the Scope as a messaging.Validator so that it can be
+// chained with messaging.WithValidation.
+func (s *Scope) Validator() messaging.Validator[string] {
+	return s.Contains
+}
+
+// ForMode returns the Validator that implements the Mode for the seeds
+func ForMode(mode Mode, seeds ...string) (messaging.Validator[string], error) {
+	parsed := make([]*url.URL, 0, len(seeds))
+	for _, seed := range seeds {
+		seedUrl, err := url.Parse(seed)
+		if err != nil {
+			return nil, fmt.Errorf("seed %q: %w", seed, err)
+		}
+		if seedUrl.Host == "" && mode != Any {
+			return nil, fmt.Errorf("seed %q has no host", seed)
+		}
+		parsed = append(parsed, seedUrl)
+	}
+
+	switch mode {
+	case Any:
+		return func(string) bool { return true }, nil
+	case Host:
+		return SameHost(parsed...), nil
+	case Domain:
+		return SameDomain(parsed...), nil
+	case Subdomain:
+		return Subdomains(parsed...), nil
+	case Prefix:
+		return PathPrefix(parsed...), nil
+	default:
+		return nil, fmt.Errorf("unknown scope %q, expected one of %v", mode, Modes)
+	}
+}
+
+// SameHost is a Validator factory that accepts urls on exactly the hosts of
+// the seeds, including the port unless it is the scheme's default.
+func SameHost(seeds ...*url.URL) messaging.Validator[string] {
+	hosts := map[string]bool{}
+	for _, seed := range seeds {
+		hosts[hostKey(seed)] = true
+	}
+	return onParsed(func(u *url.URL) bool {
+		return hosts[hostKey(u)]
+	})
+}
+
+// SameDomain is a Validator factory that accepts urls on the registrable
+// domains of the seeds, as determined by the public suffix list, or on any
+// subdomain of them. Hosts without a registrable domain, such as IP addresses
+// and localhost, are compared exactly.
+func SameDomain(seeds ...*url.URL) messaging.Validator[string] {
+	domains := map[string]bool{}
+	for _, seed := range seeds {
+		domains[RegistrableDomain(seed.Hostname())] = true
+	}
+	return onParsed(func(u *url.URL) bool {
+		return domains[RegistrableDomain(u.Hostname())]
+	})
+}
+
+// Subdomains is a Validator factory that accepts urls on the hostnames of the
+// seeds or on any of their subdomains.
+func Subdomains(seeds ...*url.URL) messaging.Validator[string] {
+	hosts := make([]string, 0, len(seeds))
+	for _, seed := range seeds {
+		hosts = append(hosts, seed.Hostname())
+	}
+	return OnHosts(hosts...)
+}
+
+// PathPrefix is a Validator factory that accepts urls on the host of a seed
+// whose path is within the seed's directory. A seed of /docs/intro allows
+// anything under /docs/.
+func PathPrefix(seeds ...*url.URL) messaging.Validator[string] {
+	type prefix struct {
+		host string
+		path string
+	}
+	prefixes := make([]prefix, 0, len(seeds))
+	for _, seed := range seeds {
+		path := seed.Path
+		path = path[:strings.LastIndex(path, "/")+1]
+		if path == "" {
+			path = "/"
+		}
+		prefixes = append(prefixes, prefix{hostKey(seed), path})
+	}
+
+	return onParsed(func(u *url.URL) bool {
+		path := u.Path
+		if path == "" {
+			path = "/"
+		}
+		host := hostKey(u)
+		for _, p := range prefixes {
+			if host == p.host && strings.HasPrefix(path, p.path) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// OnHosts is a Validator factory that accepts urls whose hostname is one of
+// the passed hosts or a subdomain of one of them.
+func OnHosts(hosts ...string) messaging.Validator[string] {
+	normalised := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		host = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(host)), "*.")
+		normalised = append(normalised, strings.TrimSuffix(host, "."))
+	}
+
+	return onParsed(func(u *url.URL) bool {
+		hostname := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+		for _, host := range normalised {
+			if hostname == host || strings.HasSuffix(hostname, "."+host) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// MatchesAny is a Validator factory that accepts urls matching at least one
+// of the patterns.
+func MatchesAny(patterns ...*regexp.Regexp) messaging.Validator[string] {
+	return func(rawUrl string) bool {
+		for _, pattern := range patterns {
+			if pattern.MatchString(rawUrl) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Schemes is a Validator factory that accepts urls with one of the schemes
+func Schemes(schemes ...string) messaging.Validator[string] {
+	return onParsed(func(u *url.URL) bool {
+		for _, scheme := range schemes {
+			if strings.EqualFold(u.Scheme, scheme) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// RegistrableDomain returns the public suffix plus one label of the hostname,
+// e.g. example.co.uk for www.example.co.uk, or the hostname itself if it
+// doesn't have one.
+func RegistrableDomain(hostname string) string {
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+	domain, err := publicsuffix.EffectiveTLDPlusOne(hostname)
+	if err != nil {
+		return hostname
+	}
+	return domain
+}
+
+// hostKey returns the host of the url in a form that can be compared: the
+// hostname lowercased and without a trailing dot, and the port only if it
+// isn't the default for the scheme.
+func hostKey(u *url.URL) string {
+	hostname := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	port := u.Port()
+	if port == "" || defaultPorts[strings.ToLower(u.Scheme)] == port {
+		return hostname
+	}
+	return hostname + ":" + port
+}
+
+// defaultPorts are the ports that a url of each scheme has if it names none
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// onParsed adapts a predicate on parsed urls to a Validator, rejecting urls
+// that can't be parsed.
+func onParsed(predicate func(u *url.URL) bool) messaging.Validator[string] {
+	return func(rawUrl string) bool {
+		parsed, err := url.Parse(rawUrl)
+		if err != nil {
+			return false
+		}
+		return predicate(parsed)
+	}
+}
@@ -0,0 +1,150 @@
+package scope
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestForMode(t *testing.T) {
+	cases := []struct {
+		mode   Mode
+		seed   string
+		url    string
+		inside bool
+	}{
+		{Any, "http://example.com/", "http://example.com/", true},
+		{Any, "http://example.com/", "https://elsewhere.org/page", true},
+
+		{Host, "http://example.com/", "http://example.com/page", true},
+		{Host, "http://example.com/", "http://EXAMPLE.com./page", true},
+		{Host, "http://example.com/", "http://www.example.com/", false},
+		{Host, "http://example.com:80/", "http://example.com:80/", true},
+		{Host, "http://example.com:80/", "http://example.com/", true},
+		{Host, "https://example.com/", "https://example.com:443/", true},
+		{Host, "http://example.com:8080/", "http://example.com:8080/page", true},
+		{Host, "http://example.com:8080/", "http://example.com/page", false},
+		{Host, "http://example.com/", "https://example.com:80/", false},
+
+		{Domain, "http://www.example.com/", "http://blog.example.com/", true},
+		{Domain, "http://www.example.com/", "http://example.com/", true},
+		{Domain, "http://www.example.co.uk/", "http://shop.example.co.uk/", true},
+		{Domain, "http://www.example.co.uk/", "http://other.co.uk/", false},
+		{Domain, "http://127.0.0.1:8080/", "http://127.0.0.1:9090/", true},
+		{Domain, "http://127.0.0.1/", "http://127.0.0.2/", false},
+
+		{Subdomain, "http://blog.example.com/", "http://blog.example.com/", true},
+		{Subdomain, "http://blog.example.com/", "http://eu.blog.example.com/", true},
+		{Subdomain, "http://blog.example.com/", "http://example.com/", false},
+		{Subdomain, "http://blog.example.com/", "http://shop.example.com/", false},
+		{Subdomain, "http://blog.example.com/", "http://notblog.example.com/", false},
+
+		{Prefix, "http://example.com/docs/intro", "http://example.com/docs/", true},
+		{Prefix, "http://example.com/docs/intro", "http://example.com/docs/api/v1", true},
+		{Prefix, "http://example.com/docs/intro", "http://example.com/blog/", false},
+		{Prefix, "http://example.com/docs/intro", "http://www.example.com/docs/", false},
+		{Prefix, "http://example.com", "http://example.com/anything", true},
+		{Prefix, "http://example.com:80/docs/", "http://example.com:80/docs/", true},
+		{Prefix, "http://example.com:80/docs/", "http://example.com/docs/page", true},
+		{Prefix, "http://example.com:8080/docs/", "http://example.com/docs/page", false},
+	}
+	for _, c := range cases {
+		validator, err := ForMode(c.mode, c.seed)
+		if err != nil {
+			t.Fatalf("ForMode(%s, %s): %v", c.mode, c.seed, err)
+		}
+		if got := validator(c.url); got != c.inside {
+			t.Errorf("%s scope of %s: %s in scope = %v, want %v", c.mode, c.seed, c.url, got, c.inside)
+		}
+	}
+}
+
+func TestForModeAcceptsItsOwnSeeds(t *testing.T) {
+	seeds := []string{
+		"http://example.com/",
+		"http://example.com:80/",
+		"https://Example.com:443/docs/intro",
+		"http://localhost:8080/",
+		"http://127.0.0.1/start",
+	}
+	for _, mode := range Modes {
+		for _, seed := range seeds {
+			validator, err := ForMode(mode, seed)
+			if err != nil {
+				t.Fatalf("ForMode(%s, %s): %v", mode, seed, err)
+			}
+			if !validator(seed) {
+				t.Errorf("the %s scope of %s rejects the seed itself", mode, seed)
+			}
+		}
+	}
+}
+
+func TestForModeNeedsAHost(t *testing.T) {
+	for _, mode := range []Mode{Host, Domain, Subdomain, Prefix} {
+		if _, err := ForMode(mode, "/relative"); err == nil {
+			t.Errorf("ForMode(%s) accepted a seed with no host", mode)
+		}
+	}
+	if _, err := ForMode(Any, "/relative"); err != nil {
+		t.Errorf("ForMode(any) = %v, want seeds without a host accepted", err)
+	}
+}
+
+func TestForSeedsCombinesModes(t *testing.T) {
+	s, err := ForSeeds(
+		Seed{Url: "http://a.example.com/", Mode: Host},
+		Seed{Url: "http://other.org/docs/intro", Mode: Prefix},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"http://a.example.com/page":  true,
+		"http://b.example.com/":      false,
+		"http://other.org/docs/page": true,
+		"http://other.org/blog/":     false,
+	}
+	for rawUrl, inside := range cases {
+		if got := s.Contains(rawUrl); got != inside {
+			t.Errorf("Contains(%s) = %v, want %v", rawUrl, got, inside)
+		}
+	}
+}
+
+func TestScopeHostListsAndPatterns(t *testing.T) {
+	s, err := New(Host, "http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.AllowHosts("cdn.net").
+		DenyHosts("private.cdn.net").
+		Exclude(regexp.MustCompile(`\.pdf$`))
+
+	cases := map[string]bool{
+		"http://example.com/page":        true,
+		"http://example.com/report.pdf":  false,
+		"http://cdn.net/script.js":       true,
+		"http://images.cdn.net/logo.png": true,
+		"http://private.cdn.net/secret":  false,
+		"http://elsewhere.org/":          false,
+	}
+	for rawUrl, inside := range cases {
+		if got := s.Contains(rawUrl); got != inside {
+			t.Errorf("Contains(%s) = %v, want %v", rawUrl, got, inside)
+		}
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for _, mode := range Modes {
+		if parsed, err := ParseMode(string(mode)); err != nil || parsed != mode {
+			t.Errorf("ParseMode(%s) = %s, %v", mode, parsed, err)
+		}
+	}
+	if parsed, err := ParseMode("Domain"); err != nil || parsed != Domain {
+		t.Errorf("ParseMode(Domain) = %s, %v, want it case insensitive", parsed, err)
+	}
+	if _, err := ParseMode("planet"); err == nil {
+		t.Error("ParseMode(planet) succeeded")
+	}
+}
@@ -0,0 +1,78 @@
+package scope
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Seed is a url that a crawl starts from, along with how far from it the
+// crawl may go
+type Seed struct {
+	Url  string
+	Mode Mode
+}
+
+// ParseSeed parses a seed written as a url, optionally followed by the name
+// of its Mode, as in "https://example.com/docs/ prefix". Seeds without a
+// Mode are given defaultMode.
+func ParseSeed(line string, defaultMode Mode) (Seed, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || len(fields) > 2 {
+		return Seed{}, fmt.Errorf("%q should be a url, optionally followed by a scope", line)
+	}
+
+	parsed, err := url.Parse(fields[0])
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return Seed{}, fmt.Errorf("%q is not an absolute url", fields[0])
+	}
+
+	seed := Seed{Url: fields[0], Mode: defaultMode}
+	if len(fields) == 2 {
+		if seed.Mode, err = ParseMode(fields[1]); err != nil {
+			return Seed{}, err
+		}
+	}
+	return seed, nil
+}
+
+// ReadSeeds reads one seed per line in the format of ParseSeed. Blank lines
+// and lines starting with # are skipped.
+func ReadSeeds(r io.Reader, defaultMode Mode) ([]Seed, error) {
+	var seeds []Seed
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		seed, err := ParseSeed(line, defaultMode)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number, err)
+		}
+		seeds = append(seeds, seed)
+	}
+	return seeds, scanner.Err()
+}
+
+// ReadSeedsFile reads the seeds in the file at path, or on stdin if path is
+// "-", as ReadSeeds does. Errors name the file.
+func ReadSeedsFile(path string, defaultMode Mode) ([]Seed, error) {
+	var file io.Reader = os.Stdin
+	if path != "-" {
+		opened, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer opened.Close()
+		file = opened
+	}
+	seeds, err := ReadSeeds(file, defaultMode)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return seeds, nil
+}
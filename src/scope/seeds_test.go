@@ -0,0 +1,125 @@
+package scope
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// seedsFile is a seeds file with comments, blank lines and a seed of each
+// kind
+const seedsFile = `# The sites to crawl
+https://example.com/
+
+  https://docs.example.com/guide/intro   prefix
+# http://skipped.example.com/
+http://other.org/ HOST
+`
+
+// seedsInFile are the seeds in seedsFile with a default Mode of Domain
+var seedsInFile = []Seed{
+	{Url: "https://example.com/", Mode: Domain},
+	{Url: "https://docs.example.com/guide/intro", Mode: Prefix},
+	{Url: "http://other.org/", Mode: Host},
+}
+
+func TestParseSeed(t *testing.T) {
+	cases := []struct {
+		line string
+		seed Seed
+	}{
+		{"https://example.com/", Seed{Url: "https://example.com/", Mode: Domain}},
+		{"https://example.com/docs/ prefix", Seed{Url: "https://example.com/docs/", Mode: Prefix}},
+		{"\thttps://example.com/  any ", Seed{Url: "https://example.com/", Mode: Any}},
+	}
+	for _, c := range cases {
+		seed, err := ParseSeed(c.line, Domain)
+		if err != nil || seed != c.seed {
+			t.Errorf("ParseSeed(%q) = %+v, %v, want %+v", c.line, seed, err, c.seed)
+		}
+	}
+}
+
+func TestParseSeedRejectsInvalidLines(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"example.com",
+		"/docs/",
+		"https://example.com/ planet",
+		"https://example.com/ host extra",
+		"https://exa mple.com/",
+		"http://%zz/",
+	} {
+		if seed, err := ParseSeed(line, Domain); err == nil {
+			t.Errorf("ParseSeed(%q) = %+v, want an error", line, seed)
+		}
+	}
+}
+
+func TestReadSeeds(t *testing.T) {
+	seeds, err := ReadSeeds(strings.NewReader(seedsFile), Domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(seeds, seedsInFile) {
+		t.Errorf("ReadSeeds() = %+v, want %+v", seeds, seedsInFile)
+	}
+}
+
+func TestReadSeedsNamesTheInvalidLine(t *testing.T) {
+	_, err := ReadSeeds(strings.NewReader("# comment\nhttps://example.com/\n\nnot a url at all\n"), Domain)
+	if err == nil || !strings.HasPrefix(err.Error(), "line 4:") {
+		t.Errorf("ReadSeeds() = %v, want an error for line 4", err)
+	}
+}
+
+func TestReadSeedsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seeds.txt")
+	if err := os.WriteFile(path, []byte(seedsFile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	seeds, err := ReadSeedsFile(path, Domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(seeds, seedsInFile) {
+		t.Errorf("ReadSeedsFile() = %+v, want %+v", seeds, seedsInFile)
+	}
+
+	if err := os.WriteFile(path, []byte("https://example.com/ planet\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadSeedsFile(path, Domain); err == nil || !strings.HasPrefix(err.Error(), path+": line 1:") {
+		t.Errorf("ReadSeedsFile() = %v, want an error naming the file and line", err)
+	}
+
+	if _, err := ReadSeedsFile(filepath.Join(t.TempDir(), "missing.txt"), Domain); err == nil {
+		t.Error("ReadSeedsFile() succeeded for a file that doesn't exist")
+	}
+}
+
+func TestReadSeedsFileFromStdin(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(path, []byte(seedsFile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+
+	original := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = original }()
+
+	seeds, err := ReadSeedsFile("-", Domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(seeds, seedsInFile) {
+		t.Errorf("ReadSeedsFile(-) = %+v, want %+v", seeds, seedsInFile)
+	}
+}
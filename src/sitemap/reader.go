@@ -0,0 +1,107 @@
+package sitemap
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"tjweldon/spider/src/fetch"
+	"tjweldon/spider/src/robots"
+)
+
+// DefaultMaxSitemaps is how many sitemaps a Reader will fetch, including
+// those listed in sitemap indexes
+const DefaultMaxSitemaps = 1000
+
+// Reader finds the sitemaps for a site and reads the pages listed in them,
+// following sitemap indexes.
+type Reader struct {
+	fetcher     fetch.Fetcher
+	robotsCache *robots.Cache
+	maxSitemaps int
+}
+
+// NewReader returns a Reader that fetches sitemaps with the fetcher
+func NewReader(fetcher fetch.Fetcher) *Reader {
+	return &Reader{fetcher: fetcher, maxSitemaps: DefaultMaxSitemaps}
+}
+
+// SetRobots is a fluent setter for the robots.Cache that the Sitemap: lines
+// of each host's robots.txt are read from
+func (r *Reader) SetRobots(robotsCache *robots.Cache) *Reader {
+	r.robotsCache = robotsCache
+	return r
+}
+
+// SetMaxSitemaps is a fluent setter for how many sitemaps are fetched
+func (r *Reader) SetMaxSitemaps(max int) *Reader {
+	r.maxSitemaps = max
+	return r
+}
+
+// Locate returns the sitemaps for the hosts of the seeds: those listed in
+// robots.txt, if there is a robots.Cache, and /sitemap.xml.
+func (r *Reader) Locate(seeds ...string) []string {
+	var (
+		located []string
+		seen    = map[string]bool{}
+	)
+	add := func(sitemapUrl string) {
+		if !seen[sitemapUrl] {
+			seen[sitemapUrl] = true
+			located = append(located, sitemapUrl)
+		}
+	}
+
+	for _, seed := range seeds {
+		parsed, err := url.Parse(seed)
+		if err != nil || parsed.Host == "" {
+			continue
+		}
+		if r.robotsCache != nil {
+			for _, listed := range r.robotsCache.Get(seed).Sitemaps {
+				add(listed)
+			}
+		}
+		root := url.URL{Scheme: parsed.Scheme, Host: parsed.Host, Path: "/sitemap.xml"}
+		add(root.String())
+	}
+	return located
+}
+
+// Read fetches the sitemaps, and any that they list in turn, calling found
+// with each page listed and the sitemap it was listed in. Sitemaps that
+// can't be fetched or parsed are logged and skipped.
+func (r *Reader) Read(ctx context.Context, sitemapUrls []string, found func(page Entry, sitemapUrl string)) {
+	pending := append([]string{}, sitemapUrls...)
+	fetched := map[string]bool{}
+
+	for len(pending) > 0 && len(fetched) < r.maxSitemaps {
+		if ctx.Err() != nil {
+			return
+		}
+		sitemapUrl := pending[0]
+		pending = pending[1:]
+		if fetched[sitemapUrl] {
+			continue
+		}
+		fetched[sitemapUrl] = true
+
+		result := r.fetcher.Fetch(ctx, sitemapUrl)
+		if !result.Ok() {
+			log.Printf("Sitemap %s: %v", sitemapUrl, result.Err)
+			continue
+		}
+		document, err := Parse(result.Body)
+		if err != nil {
+			log.Printf("Sitemap %s: %v", sitemapUrl, err)
+			continue
+		}
+
+		for _, child := range document.Sitemaps {
+			pending = append(pending, child.Loc)
+		}
+		for _, page := range document.Urls {
+			found(page, sitemapUrl)
+		}
+	}
+}
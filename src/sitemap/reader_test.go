@@ -0,0 +1,97 @@
+package sitemap
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"tjweldon/spider/src/fetch"
+	"tjweldon/spider/src/robots"
+)
+
+// serveSitemaps starts a server with the documents at their paths, and a
+// robots.txt listing the sitemaps in robotsSitemaps. Every document may refer
+// to the server's own url as %[1]s.
+func serveSitemaps(t *testing.T, documents map[string]string, robotsSitemaps ...string) *httptest.Server {
+	t.Helper()
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			for _, path := range robotsSitemaps {
+				fmt.Fprintf(w, "Sitemap: %s%s\n", server.URL, path)
+			}
+			return
+		}
+		document, ok := documents[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, document, server.URL)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestLocate(t *testing.T) {
+	server := serveSitemaps(t, nil, "/listed.xml", "/sitemap.xml")
+
+	reader := NewReader(fetch.NewHttpFetcher())
+	got := reader.Locate(server.URL+"/docs/", "https://example.com/a", "https://example.com/b", "not a url")
+	want := []string{server.URL + "/sitemap.xml", "https://example.com/sitemap.xml"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Locate() = %v, want %v", got, want)
+	}
+
+	reader.SetRobots(robots.NewCache("spider"))
+	got = reader.Locate(server.URL + "/docs/")
+	want = []string{server.URL + "/listed.xml", server.URL + "/sitemap.xml"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Locate() with robots = %v, want %v", got, want)
+	}
+}
+
+func TestReadFollowsIndexes(t *testing.T) {
+	server := serveSitemaps(t, map[string]string{
+		"/sitemap.xml": `<sitemapindex>
+			<sitemap><loc>%[1]s/pages.xml</loc></sitemap>
+			<sitemap><loc>%[1]s/more.xml</loc></sitemap>
+			<sitemap><loc>%[1]s/missing.xml</loc></sitemap>
+			<sitemap><loc>%[1]s/sitemap.xml</loc></sitemap>
+		</sitemapindex>`,
+		"/pages.xml": `<urlset><url><loc>%[1]s/a</loc></url><url><loc>%[1]s/b</loc></url></urlset>`,
+		"/more.xml":  `<urlset><url><loc>%[1]s/c</loc></url></urlset>`,
+	})
+
+	var found []string
+	NewReader(fetch.NewHttpFetcher()).Read(context.Background(), []string{server.URL + "/sitemap.xml"},
+		func(page Entry, sitemapUrl string) {
+			found = append(found, page.Loc+" in "+sitemapUrl)
+		})
+	sort.Strings(found)
+	want := []string{
+		server.URL + "/a in " + server.URL + "/pages.xml",
+		server.URL + "/b in " + server.URL + "/pages.xml",
+		server.URL + "/c in " + server.URL + "/more.xml",
+	}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("Read() found %v, want %v", found, want)
+	}
+}
+
+func TestReadStopsAtMaxSitemaps(t *testing.T) {
+	server := serveSitemaps(t, map[string]string{
+		"/sitemap.xml": `<sitemapindex><sitemap><loc>%[1]s/pages.xml</loc></sitemap></sitemapindex>`,
+		"/pages.xml":   `<urlset><url><loc>%[1]s/a</loc></url></urlset>`,
+	})
+
+	found := 0
+	NewReader(fetch.NewHttpFetcher()).SetMaxSitemaps(1).
+		Read(context.Background(), []string{server.URL + "/sitemap.xml"}, func(Entry, string) { found++ })
+	if found != 0 {
+		t.Errorf("Read() found %d pages, want the listed sitemap left unfetched", found)
+	}
+}
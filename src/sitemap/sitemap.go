@@ -0,0 +1,92 @@
+package sitemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// MaxSize is the most a sitemap may be once decompressed, as set by the
+	// sitemap protocol
+	MaxSize = 50 * 1024 * 1024
+
+	// MaxUrls is the most urls that one sitemap may list
+	MaxUrls = 50000
+
+	// Namespace is the xml namespace of the sitemap protocol
+	Namespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+)
+
+// Entry is a url listed in a sitemap, which is either a page in a urlset or
+// another sitemap in a sitemap index
+type Entry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// Document is a parsed sitemap. A urlset has only Urls and a sitemap index
+// has only Sitemaps.
+type Document struct {
+	Urls     []Entry
+	Sitemaps []Entry
+}
+
+// IsIndex is true for a sitemap index
+func (d *Document) IsIndex() bool {
+	return len(d.Sitemaps) > 0
+}
+
+// Parse parses a urlset or sitemap index, which may be gzipped
+func Parse(data []byte) (*Document, error) {
+	var reader io.Reader = bytes.NewReader(data)
+	if isGzip(data) {
+		unzipped, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer unzipped.Close()
+		reader = unzipped
+	}
+
+	var root struct {
+		XMLName  xml.Name
+		Urls     []Entry `xml:"url"`
+		Sitemaps []Entry `xml:"sitemap"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(reader, MaxSize)).Decode(&root); err != nil {
+		return nil, fmt.Errorf("parsing sitemap: %w", err)
+	}
+
+	switch root.XMLName.Local {
+	case "urlset":
+		return &Document{Urls: trim(root.Urls)}, nil
+	case "sitemapindex":
+		return &Document{Sitemaps: trim(root.Sitemaps)}, nil
+	default:
+		return nil, fmt.Errorf("parsing sitemap: unexpected <%s> element", root.XMLName.Local)
+	}
+}
+
+// isGzip checks for the gzip magic number, since sitemaps are gzipped
+// whatever their Content-Type says
+func isGzip(data []byte) bool {
+	return len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b
+}
+
+// trim removes the whitespace that often surrounds <loc> values, and
+// entries without one
+func trim(entries []Entry) []Entry {
+	trimmed := entries[:0]
+	for _, entry := range entries {
+		entry.Loc = strings.TrimSpace(entry.Loc)
+		entry.LastMod = strings.TrimSpace(entry.LastMod)
+		if entry.Loc != "" {
+			trimmed = append(trimmed, entry)
+		}
+	}
+	return trimmed
+}
@@ -0,0 +1,127 @@
+package sitemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const urlset = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url>
+    <loc>
+      https://example.com/
+    </loc>
+    <lastmod>2024-03-01</lastmod>
+  </url>
+  <url><loc>https://example.com/a?x=1&amp;y=2</loc></url>
+  <url><lastmod>2024-03-01</lastmod></url>
+</urlset>`
+
+const sitemapIndex = `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>https://example.com/sitemap-1.xml</loc></sitemap>
+  <sitemap><loc>https://example.com/sitemap-2.xml.gz</loc><lastmod>2024-03-01</lastmod></sitemap>
+</sitemapindex>`
+
+// urlsetEntries are the pages listed in urlset
+var urlsetEntries = []Entry{
+	{Loc: "https://example.com/", LastMod: "2024-03-01"},
+	{Loc: "https://example.com/a?x=1&y=2"},
+}
+
+// gzipped compresses the document
+func gzipped(t *testing.T, document string) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write([]byte(document)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestParseUrlset(t *testing.T) {
+	document, err := Parse([]byte(urlset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if document.IsIndex() || !reflect.DeepEqual(document.Urls, urlsetEntries) {
+		t.Errorf("Parse() = %+v, want %+v", document, urlsetEntries)
+	}
+}
+
+func TestParseGzip(t *testing.T) {
+	document, err := Parse(gzipped(t, urlset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(document.Urls, urlsetEntries) {
+		t.Errorf("Parse() = %+v, want %+v", document.Urls, urlsetEntries)
+	}
+
+	if _, err := Parse([]byte{0x1f, 0x8b, 'n', 'o', 't'}); err == nil {
+		t.Error("Parse() accepted a broken gzip stream")
+	}
+}
+
+func TestParseSitemapIndex(t *testing.T) {
+	document, err := Parse([]byte(sitemapIndex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{Loc: "https://example.com/sitemap-1.xml"},
+		{Loc: "https://example.com/sitemap-2.xml.gz", LastMod: "2024-03-01"},
+	}
+	if !document.IsIndex() || len(document.Urls) != 0 || !reflect.DeepEqual(document.Sitemaps, want) {
+		t.Errorf("Parse() = %+v, want the index of %+v", document, want)
+	}
+}
+
+func TestParseRejectsOtherDocuments(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"not xml",
+		`<html><body><a href="/">home</a></body></html>`,
+		`<urlset><url><loc>https://example.com/</loc>`,
+	} {
+		if document, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Parse(%q) = %+v, want an error", data, document)
+		}
+	}
+}
+
+func TestParseStopsAtMaxSize(t *testing.T) {
+	var document strings.Builder
+	document.WriteString(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`)
+	document.WriteString(strings.Repeat(" ", MaxSize))
+	document.WriteString(`<url><loc>https://example.com/</loc></url></urlset>`)
+	if _, err := Parse(gzipped(t, document.String())); err == nil {
+		t.Error("Parse() read past MaxSize of decompressed sitemap")
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	for _, document := range []*Document{
+		{Urls: urlsetEntries},
+		{Sitemaps: []Entry{{Loc: "https://example.com/sitemap-1.xml", LastMod: "2024-03-01T00:00:00Z"}}},
+	} {
+		marshalled, err := document.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := Parse(marshalled)
+		if err != nil {
+			t.Fatalf("Parse(Marshal()) = %v for\n%s", err, marshalled)
+		}
+		if !reflect.DeepEqual(parsed, document) {
+			t.Errorf("Parse(Marshal()) = %+v, want %+v", parsed, document)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package sitemap
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// LastModFormat is the W3C datetime format used for lastmod values
+const LastModFormat = "2006-01-02T15:04:05Z07:00"
+
+// LastMod formats a time as a lastmod value, in UTC
+func LastMod(t time.Time) string {
+	return t.UTC().Format(LastModFormat)
+}
+
+// Marshal writes the document as a urlset, or as a sitemap index if it lists
+// any sitemaps, including the xml declaration.
+func (d *Document) Marshal() ([]byte, error) {
+	type urlset struct {
+		XMLName xml.Name `xml:"urlset"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Urls    []Entry  `xml:"url"`
+	}
+	type sitemapindex struct {
+		XMLName  xml.Name `xml:"sitemapindex"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Sitemaps []Entry  `xml:"sitemap"`
+	}
+
+	var root interface{} = urlset{Xmlns: Namespace, Urls: d.Urls}
+	if d.IsIndex() {
+		root = sitemapindex{Xmlns: Namespace, Sitemaps: d.Sitemaps}
+	}
+	marshalled, err := xml.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), marshalled...), nil
+}
+
+// Split divides the urls into groups that each fit in one sitemap, of no
+// more than maxUrls urls and MaxSize bytes. maxUrls is capped at MaxUrls.
+func Split(urls []Entry, maxUrls int) [][]Entry {
+	if maxUrls <= 0 || maxUrls > MaxUrls {
+		maxUrls = MaxUrls
+	}
+	// overhead is the size of the urlset element and declaration, and
+	// perEntry the size of the markup around each entry, generously
+	const overhead, perEntry = 256, 64
+
+	var groups [][]Entry
+	var group []Entry
+	size := overhead
+	for _, entry := range urls {
+		entrySize := perEntry + escapedLen(entry.Loc) + len(entry.LastMod)
+		if len(group) == maxUrls || len(group) > 0 && size+entrySize > MaxSize {
+			groups = append(groups, group)
+			group, size = nil, overhead
+		}
+		group = append(group, entry)
+		size += entrySize
+	}
+	if len(group) > 0 || len(groups) == 0 {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// escapedLen is the length of s once it is escaped as xml text
+func escapedLen(s string) int {
+	length := len(s)
+	for _, c := range s {
+		switch c {
+		case '&':
+			length += 4
+		case '<', '>':
+			length += 3
+		case '"', '\'':
+			length += 4
+		}
+	}
+	return length
+}
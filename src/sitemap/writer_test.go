@@ -0,0 +1,100 @@
+package sitemap
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// entries returns n pages with locs of the length
+func entries(n, length int) []Entry {
+	urls := make([]Entry, n)
+	for i := range urls {
+		loc := fmt.Sprintf("https://example.com/%d/", i)
+		urls[i] = Entry{Loc: loc + strings.Repeat("x", length-len(loc))}
+	}
+	return urls
+}
+
+// sizes returns the number of entries in each group
+func sizes(groups [][]Entry) []int {
+	counts := make([]int, len(groups))
+	for i, group := range groups {
+		counts[i] = len(group)
+	}
+	return counts
+}
+
+func TestSplitByCount(t *testing.T) {
+	cases := []struct {
+		urls, maxUrls int
+		want          string
+	}{
+		{0, 10, "[0]"},
+		{5, 10, "[5]"},
+		{10, 10, "[10]"},
+		{11, 10, "[10 1]"},
+		{25, 10, "[10 10 5]"},
+		{3, 1, "[1 1 1]"},
+		{MaxUrls + 1, 0, fmt.Sprint([]int{MaxUrls, 1})},
+		{MaxUrls + 1, MaxUrls * 2, fmt.Sprint([]int{MaxUrls, 1})},
+	}
+	for _, c := range cases {
+		groups := Split(entries(c.urls, 30), c.maxUrls)
+		if got := fmt.Sprint(sizes(groups)); got != c.want {
+			t.Errorf("Split(%d urls, %d) = %s, want %s", c.urls, c.maxUrls, got, c.want)
+		}
+	}
+}
+
+func TestSplitKeepsTheOrder(t *testing.T) {
+	urls := entries(7, 30)
+	var joined []Entry
+	for _, group := range Split(urls, 3) {
+		joined = append(joined, group...)
+	}
+	if fmt.Sprint(joined) != fmt.Sprint(urls) {
+		t.Errorf("the split groups hold %v, want %v", joined, urls)
+	}
+}
+
+func TestSplitBySize(t *testing.T) {
+	// Each entry is around 1 MB, so only so many fit in MaxSize
+	urls := entries(120, 1024*1024)
+	groups := Split(urls, MaxUrls)
+	if len(groups) < 3 {
+		t.Fatalf("Split() = %v groups, want the urls split by size", sizes(groups))
+	}
+	for i, group := range groups {
+		document := &Document{Urls: group}
+		marshalled, err := document.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(marshalled) > MaxSize {
+			t.Errorf("group %d is %d bytes, more than MaxSize", i, len(marshalled))
+		}
+	}
+}
+
+func TestSplitCountsEscaping(t *testing.T) {
+	// Escaping each & as &amp; makes these entries five times as big, so
+	// that eleven of them are more than MaxSize
+	urls := make([]Entry, 11)
+	for i := range urls {
+		urls[i] = Entry{Loc: "https://example.com/?" + strings.Repeat("&", 1024*1024)}
+	}
+	groups := Split(urls, MaxUrls)
+	if len(groups) < 2 {
+		t.Fatalf("Split() = %v groups, want the escaped size to be counted", sizes(groups))
+	}
+	for i, group := range groups {
+		marshalled, err := (&Document{Urls: group}).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(marshalled) > MaxSize {
+			t.Errorf("group %d is %d bytes, more than MaxSize", i, len(marshalled))
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package swarm
+
+import (
+	"regexp"
+	"strings"
+	"tjweldon/spider/src/jobs"
+	"tjweldon/spider/src/links"
+	"tjweldon/spider/src/messaging"
+)
+
+// ContentHandler processes a fetched document that isn't HTML
+type ContentHandler func(page *Page)
+
+// Then composes ContentHandlers sequentially, in the same way as
+// NodeScraper.Then
+func (ch1 ContentHandler) Then(ch2 ContentHandler) ContentHandler {
+	return func(page *Page) {
+		ch1(page)
+		ch2(page)
+	}
+}
+
+// ContentHandlers is the registry of ContentHandlers by media type. Keys are
+// either a full media type such as "text/css" or a wildcard like "image/*".
+type ContentHandlers map[string]ContentHandler
+
+// For returns the handler for the media type, preferring an exact match over
+// a wildcard.
+func (ch ContentHandlers) For(mediaType string) (ContentHandler, bool) {
+	if handler, ok := ch[mediaType]; ok {
+		return handler, true
+	}
+	if major, _, found := strings.Cut(mediaType, "/"); found {
+		if handler, ok := ch[major+"/*"]; ok {
+			return handler, true
+		}
+	}
+	handler, ok := ch["*/*"]
+	return handler, ok
+}
+
+// IsHtml is true for the media types that are parsed into a node tree
+func IsHtml(mediaType string) bool {
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// cssUrlPattern matches url(...) references and @import strings in CSS
+var cssUrlPattern = regexp.MustCompile(
+	`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)'"\s]*))\s*\)|@import\s+(?:"([^"]*)"|'([^']*)')`,
+)
+
+// RecoverCssUrls is a factory for ContentHandlers that pass the urls
+// referenced by a stylesheet to the passed Dispatcher, the way RecoverUrls
+// does for HTML.
+func RecoverCssUrls(dispatcher messaging.Dispatcher[jobs.Job]) ContentHandler {
+	return func(page *Page) {
+		for _, resolved := range cssUrls(page) {
+			if !dispatcher.Dispatch(page.Follow(resolved)) {
+				return
+			}
+		}
+	}
+}
+
+// RecordCssLinks is a factory for ContentHandlers that pass each url
+// referenced by a stylesheet to the passed Dispatcher as a Link, the way
+// RecordLinks does for HTML.
+func RecordCssLinks(dispatcher messaging.Dispatcher[links.Link]) ContentHandler {
+	return func(page *Page) {
+		for _, resolved := range cssUrls(page) {
+			dispatcher.Dispatch(links.Link{
+				Source:  page.Url.String(),
+				Target:  resolved,
+				Element: "style",
+			})
+		}
+	}
+}
+
+// cssUrls returns the urls referenced by a stylesheet, resolved against it
+func cssUrls(page *Page) []string {
+	var found []string
+	for _, match := range cssUrlPattern.FindAllStringSubmatch(string(page.Result.Body), -1) {
+		for _, ref := range match[1:] {
+			if ref == "" || strings.HasPrefix(ref, "data:") {
+				continue
+			}
+			if resolved, ok := page.Resolve(ref); ok {
+				found = append(found, resolved)
+			}
+		}
+	}
+	return found
+}
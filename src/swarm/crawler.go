@@ -1,10 +1,16 @@
 package swarm
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"golang.org/x/net/html"
 	"log"
 	"time"
+	"tjweldon/spider/src/fetch"
+	"tjweldon/spider/src/jobs"
 	"tjweldon/spider/src/messaging"
+	"tjweldon/spider/src/records"
 	"tjweldon/spider/src/util"
 )
 
@@ -28,30 +34,77 @@ type Crawler struct {
 	// html tree
 	Root *html.Node
 
+	// Page is the context of the document currently being crawled, which
+	// scrapers use to resolve relative links
+	Page *Page
+
 	// Done is the channel that a crawler uses to indicate that it has scraped
 	// a node
 	Done chan Signal
 
 	// Ready is a flag that is set to true if the crawler is ready for more work
 	Ready bool
+
+	// fetcher retrieves the documents to crawl
+	fetcher fetch.Fetcher
+
+	// throttle, if set, is waited on before each fetch so that per-host
+	// crawl delays are honoured
+	throttle Throttle
+
+	// retry decides which failed fetches are worth another attempt, which
+	// are then re-enqueued with requeue if it is set
+	retry   fetch.RetryPolicy
+	requeue messaging.Dispatcher[jobs.Job]
+
+	// handlers deal with documents that aren't HTML, and headFirst makes
+	// the crawler check the type of a document before downloading it
+	handlers  ContentHandlers
+	headFirst bool
+
+	// claimer, if set, is asked whether a page reached by a redirect still
+	// needs crawling, so that each final url is only crawled once
+	claimer messaging.Claimer[jobs.Job]
+
+	// canonicalise, if set, is the canonicaliser the jobs' urls went through,
+	// so that a redirect back to the job's own key isn't claimed again
+	canonicalise messaging.PreProcessor[string]
+
+	// records, if set, is sent a record of every page fetched, once it has
+	// been crawled
+	records messaging.Dispatcher[records.Page]
+}
+
+// Throttle is implemented by anything that can hold up a fetch until it is
+// polite to make it, such as a robots.Cache honouring Crawl-delay.
+type Throttle interface {
+	Wait(ctx context.Context, target string) error
 }
 
 // NewCrawler creates a Crawler and hands us a pointer to it
 func NewCrawler() *Crawler {
 	done := make(chan Signal)
 	return &Crawler{
-		Done:  done,
-		Ready: true,
+		Done:     done,
+		Ready:    true,
+		fetcher:  fetch.NewHttpFetcher(),
+		handlers: ContentHandlers{},
 	}
 }
 
+// SetFetcher is a fluent setter for the Fetcher that retrieves documents
+func (c *Crawler) SetFetcher(f fetch.Fetcher) *Crawler {
+	c.fetcher = f
+	return c
+}
+
 // Scrape iterates over each FilteredScraper in Crawler.Scrapers, applies that
 // FilteredScraper's filter to ignore irrelevant nodes and then if not filtered
 // out, it scrapes the node
-func (c *Crawler) Scrape(node *html.Node) {
+func (c *Crawler) Scrape(node *html.Node, page *Page) {
 	for _, scraper := range c.Scrapers {
 		if scraper.Filter(node) {
-			scraper.Scrape(node)
+			scraper.Scrape(node, page)
 		}
 	}
 }
@@ -66,21 +119,112 @@ func (c *Crawler) AddScraper(s NodeScraper, f NodeFilter) *Crawler {
 	return c
 }
 
-// CrawlNow is a blocking recursive walk over the node tree. Each node is passed
-// to the configured Scrapers. If there is an error retrieving the response,
-// CrawlNow just returns so it can be made ready to pick up another job.
-func (c *Crawler) CrawlNow(target string) {
-	var f NodeScraper
-	c.Root = nil
-	f = func(n *html.Node) {
-		c.Scrape(n)
-		for child := n.FirstChild; child != nil; child = child.NextSibling {
-			f(child)
+// AddContentHandler provides a fluent interface to register a ContentHandler
+// for documents of a media type that isn't HTML. The media type may be a
+// wildcard such as "image/*".
+func (c *Crawler) AddContentHandler(mediaType string, h ContentHandler) *Crawler {
+	c.handlers[mediaType] = h
+	return c
+}
+
+// SetHeadFirst is a fluent setter for whether to make a HEAD request before
+// each fetch, so that documents nothing can handle aren't downloaded.
+func (c *Crawler) SetHeadFirst(headFirst bool) *Crawler {
+	c.headFirst = headFirst
+	return c
+}
+
+// SetThrottle is a fluent setter for the Throttle waited on before each fetch
+func (c *Crawler) SetThrottle(t Throttle) *Crawler {
+	c.throttle = t
+	return c
+}
+
+// SetRetry is a fluent setter for the policy that decides which failed fetches
+// are retried, and the dispatcher they are re-enqueued on. This should be the
+// queue itself rather than anything that deduplicates.
+func (c *Crawler) SetRetry(policy fetch.RetryPolicy, requeue messaging.Dispatcher[jobs.Job]) *Crawler {
+	c.retry = policy
+	c.requeue = requeue
+	return c
+}
+
+// SetClaimer is a fluent setter for the Claimer that decides whether a page
+// reached by a redirect is crawled. This should be the dispatcher that the
+// crawlers' links are sent to, so that the final url is deduplicated along
+// with them.
+func (c *Crawler) SetClaimer(claimer messaging.Claimer[jobs.Job]) *Crawler {
+	c.claimer = claimer
+	return c
+}
+
+// SetCanonicaliser is a fluent setter for the canonicaliser that the urls of
+// jobs are put through, which is used to tell whether a redirect only led to
+// another form of the job's own url.
+func (c *Crawler) SetCanonicaliser(canonicalise messaging.PreProcessor[string]) *Crawler {
+	c.canonicalise = canonicalise
+	return c
+}
+
+// SetRecords is a fluent setter for the dispatcher that a record of each page
+// fetched is sent to. Retried fetches aren't recorded.
+func (c *Crawler) SetRecords(records messaging.Dispatcher[records.Page]) *Crawler {
+	c.records = records
+	return c
+}
+
+// CrawlNow fetches the job's document and handles it according to its media
+// type. HTML is parsed and walked recursively, with each node passed to the
+// configured Scrapers. Anything else goes to the ContentHandler registered
+// for its type, or is skipped if there isn't one. If the document can't be
+// retrieved or parsed, CrawlNow returns the error so it can be made ready to
+// pick up another job. The context cancels the fetch.
+func (c *Crawler) CrawlNow(ctx context.Context, job jobs.Job) (err error) {
+	c.Root, c.Page = nil, nil
+
+	if c.headFirst && !c.worthFetching(ctx, job) {
+		return nil
+	}
+
+	result, err := c.fetch(ctx, job)
+	if result != nil && c.records != nil {
+		defer func() { c.records.Dispatch(c.record(job, result, err)) }()
+	}
+	if err != nil || result == nil {
+		return err
+	}
+	if !c.claimRedirect(job, result) {
+		log.Printf("Skipping %s: redirected to %s", job.Url, result.FinalUrl)
+		return nil
+	}
+
+	mediaType := result.MediaType()
+	if !IsHtml(mediaType) {
+		handler, ok := c.handlers.For(mediaType)
+		if !ok {
+			log.Printf("Skipping %s: %s", mediaType, job.Url)
+			return nil
 		}
+		c.Page = NewPage(job, result, nil)
+		handler(c.Page)
+		return nil
+	}
+
+	tree, err := html.Parse(bytes.NewReader(result.Body))
+	if err != nil {
+		return fmt.Errorf("%s: parsing html: %w", job.Url, err)
 	}
-	tree := c.populateNodeTree(target)
-	if tree != nil {
-		f(tree)
+	c.Root = tree
+	c.Page = NewPage(job, result, tree)
+	c.walk(tree)
+	return nil
+}
+
+// walk passes the node and all of its descendants to the Scrapers
+func (c *Crawler) walk(n *html.Node) {
+	c.Scrape(n, c.Page)
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.walk(child)
 	}
 }
 
@@ -88,28 +232,112 @@ type Signal struct{}
 
 // Crawl is non-blocking. Will report completion on the chan Signal
 // passed if not nil.
-func (c *Crawler) Crawl(target string) {
-	log.Println("Beginning crawl... target: " + target)
-	go func(d chan Signal, t string) {
-		c.CrawlNow(t)
+func (c *Crawler) Crawl(ctx context.Context, job jobs.Job) {
+	log.Println("Beginning crawl... target: " + job.Url)
+	go func(d chan Signal, j jobs.Job) {
+		if err := c.CrawlNow(ctx, j); err != nil {
+			log.Printf("Crawl failed: %v", err)
+		}
 		d <- Signal{}
-	}(c.Done, target)
+	}(c.Done, job)
 }
 
-// populateNodeTree retrieves the html from the target URL and parses it
-// into a node tree. It then stores it in Crawler.Root.
-func (c *Crawler) populateNodeTree(target string) *html.Node {
-	resp := util.GetOrNil(target)
-	if resp == nil {
-		return nil
+// fetch retrieves the job's document. If the fetch fails but is worth
+// retrying, the job is re-enqueued and a nil result is returned with no
+// error. Otherwise a failed fetch returns its result along with the error.
+func (c *Crawler) fetch(ctx context.Context, job jobs.Job) (*fetch.Result, error) {
+	if c.throttle != nil {
+		if err := c.throttle.Wait(ctx, job.Url); err != nil {
+			return nil, err
+		}
 	}
-	parentNode, err := html.Parse(resp.Body)
-	if err != nil {
-		log.Fatal(err)
+
+	result := c.fetcher.Fetch(ctx, job.Url)
+	if !result.Ok() {
+		if delay, ok := c.retry.Next(ctx, result, job.Attempt+1); ok && c.requeue != nil {
+			log.Printf("Crawl failed: %s: %v, retrying in %v", job.Url, result.Err, delay)
+			c.requeue.Dispatch(job.Retry(delay))
+			return nil, nil
+		}
+		return result, fmt.Errorf("%s: %w", job.Url, result.Err)
+	}
+	return result, nil
+}
+
+// record describes the job's page once it has been crawled, along with the
+// error crawling it, if there was one. The title and links are only found
+// for documents that were parsed or handled.
+func (c *Crawler) record(job jobs.Job, result *fetch.Result, err error) records.Page {
+	record := records.Page{
+		Url:         job.Url,
+		Status:      result.Status,
+		ContentType: result.ContentType(),
+		Size:        len(result.Body),
+		Fetched:     result.Started,
+		DurationMs:  float64(result.Duration) / float64(time.Millisecond),
+		Depth:       job.Depth,
+		Referrer:    job.Referrer,
+		Result:      result,
+	}
+	if result.FinalUrl != nil {
+		record.FinalUrl = result.FinalUrl.String()
+	}
+	if result.Err != nil {
+		record.Error = result.Err.Error()
+	} else if err != nil {
+		record.Error = err.Error()
+	}
+	if c.Page != nil && c.Page.Result == result {
+		record.Title = findTitle(c.Root)
+		record.Links = countLinks(c.Root, c.Page)
+	}
+	return record
+}
+
+// claimRedirect returns false if the job was redirected to a page that has
+// been or will be crawled as a job of its own, or that is out of bounds.
+func (c *Crawler) claimRedirect(job jobs.Job, result *fetch.Result) bool {
+	if !result.Redirected() || c.claimer == nil || result.FinalUrl == nil {
+		return true
 	}
-	c.Root = parentNode
+	final := job
+	final.Url = result.FinalUrl.String()
+	if c.canonicalise != nil {
+		final.Url = c.canonicalise(final.Url)
+	}
+	// The job's own key is already claimed, so a redirect to another form of
+	// its url, such as with a trailing slash, isn't a duplicate
+	if final.Key() == job.Key() {
+		return true
+	}
+	return c.claimer.Claim(final)
+}
 
-	return parentNode
+// worthFetching makes a HEAD request, if the Fetcher can, to find out
+// whether the document is HTML or has a ContentHandler before downloading
+// it. If the HEAD request fails or gives no Content-Type, the document is
+// fetched anyway.
+func (c *Crawler) worthFetching(ctx context.Context, job jobs.Job) bool {
+	prober, ok := c.fetcher.(fetch.Prober)
+	if !ok {
+		return true
+	}
+	if c.throttle != nil {
+		if err := c.throttle.Wait(ctx, job.Url); err != nil {
+			return false
+		}
+	}
+
+	result := prober.Probe(ctx, job.Url)
+	if !result.Ok() || result.ContentType() == "" {
+		return true
+	}
+	mediaType := result.MediaType()
+	if _, handled := c.handlers.For(mediaType); IsHtml(mediaType) || handled {
+		return true
+	}
+	log.Printf("Skipping %s: %s", mediaType, job.Url)
+	return false
 }
 
 // Die is the crawler teardown function
@@ -118,15 +346,21 @@ func (c *Crawler) Die() {
 }
 
 // getWorker returns the worker for this crawler
-func (c *Crawler) getWorker(incoming messaging.Backlog[string], id int) *Worker {
-	return &Worker{id, c, incoming, make(chan Signal)}
+func (c *Crawler) getWorker(incoming messaging.Backlog[jobs.Job], id int) *Worker {
+	return &Worker{
+		id:       id,
+		crawler:  c,
+		incoming: incoming,
+		done:     make(chan Signal),
+		retire:   make(chan Signal),
+	}
 }
 
 // Work is a convenience method that encapsulates getting the Worker, setting
 // it running and returning a pointer to it back to the calling scope
-func (c *Crawler) Work(incoming messaging.Backlog[string], id int) *Worker {
+func (c *Crawler) Work(ctx context.Context, incoming messaging.Backlog[jobs.Job], id int) *Worker {
 	worker := c.getWorker(incoming, id)
-	go worker.Run()
+	go worker.Run(ctx)
 	log.Printf("Worker %d: Worker Started", worker.id)
 	return worker
 }
@@ -137,51 +371,88 @@ func (c *Crawler) Work(incoming messaging.Backlog[string], id int) *Worker {
 type Worker struct {
 	id       int
 	crawler  *Crawler
-	incoming messaging.Backlog[string]
+	incoming messaging.Backlog[jobs.Job]
 	done     chan Signal
+
+	// retire is closed to ask the worker to stop after its current job
+	retire   chan Signal
+	retiring bool
+
+	// latency, if set, is told how long each crawl takes
+	latency *latencyWindow
 }
 
 // Run is the worker function that runs in a goroutine to
-// actually do the work.
-func (w *Worker) Run() {
-	var done bool
-	for !done {
+// actually do the work. It blocks waiting for jobs until the
+// backlog is closed, the worker is retired or the context is
+// cancelled.
+func (w *Worker) Run(ctx context.Context) {
+	defer close(w.done)
+	for {
 		select {
+		case <-ctx.Done():
+			log.Printf("Worker %d: Cancelled", w.id)
+			return
+		case <-w.retire:
+			log.Printf("Worker %d: Retired", w.id)
+			return
 		case job, ok := <-w.incoming.Channel():
 			if !ok {
-				done = true
-				break
+				log.Printf("Worker %d: No more jobs, done.", w.id)
+				return
+			}
+			w.awaitReady(ctx, job)
+			start := time.Now()
+			if err := w.crawler.CrawlNow(ctx, job); err != nil {
+				log.Printf("Worker %d: Crawl failed: %v", w.id, err)
+			}
+			if ctx.Err() != nil {
+				// The crawl was cut short, so the job is left unacknowledged
+				// for a resumed crawl to pick up again
+				log.Printf("Worker %d: Cancelled", w.id)
+				return
 			}
-			w.crawler.CrawlNow(job)
-		default:
-			if w.hasNoWork() {
-				done = true
-				break
+			if w.latency != nil {
+				w.latency.Observe(time.Since(start))
 			}
+			w.ack(job)
 		}
 	}
-	close(w.done)
 }
 
-// hasNoWork returns true if there is no work after 5 seconds
-func (w *Worker) hasNoWork() bool {
-	noJobs := false
-	jobCount := 0
-
-	for range [4]any{} {
-		jobCount += w.incoming.Length()
-		if jobCount == 0 {
-			log.Printf("Worker %d: No jobs, waiting...", w.id)
-			time.Sleep(time.Second)
-			jobCount += w.incoming.Length()
-		}
+// awaitReady holds on to a retried job until it is due. Backlogs such as the
+// HostScheduler only deliver jobs when they are due, so this only waits for
+// those that don't.
+func (w *Worker) awaitReady(ctx context.Context, job jobs.Job) {
+	wait := time.Until(job.NotBefore)
+	if wait <= 0 {
+		return
 	}
-	if jobCount == 0 {
-		log.Printf("Worker %d: Still no jobs, done.", w.id)
-		noJobs = true
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
 	}
+}
 
-	return noJobs
+// ack lets the backlog know the job is finished with, if it wants to know
+func (w *Worker) ack(job jobs.Job) {
+	if acknowledger, ok := w.incoming.(messaging.Acknowledger[jobs.Job]); ok {
+		acknowledger.Ack(job)
+	}
+}
+
+// Retire asks the worker to stop once it has finished its current job. It
+// returns false if the worker was already retiring. It is not safe to call
+// concurrently.
+func (w *Worker) Retire() bool {
+	if w.retiring {
+		return false
+	}
+	w.retiring = true
+	close(w.retire)
+	return true
 }
 
 // IsDone returns true if the worker backlog channel has been closed
@@ -0,0 +1,138 @@
+package swarm
+
+import (
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"net/url"
+	"strings"
+	"tjweldon/spider/src/fetch"
+	"tjweldon/spider/src/jobs"
+)
+
+// Page is the context that a node is scraped in: the job that led to the
+// document, the result of fetching it, the url it was actually retrieved from
+// and the base that relative links in it resolve against.
+type Page struct {
+	// Job is the job the document was crawled for
+	Job jobs.Job
+
+	// Result is the response the document came from
+	Result *fetch.Result
+
+	// Url is the location of the document, after any redirects
+	Url *url.URL
+
+	// Base is the url relative links are resolved against. It is the same as
+	// Url unless the document has a <base href>.
+	Base *url.URL
+}
+
+// NewPage creates the Page for a job's document, picking up the <base href>
+// from the node tree if it has one.
+func NewPage(job jobs.Job, result *fetch.Result, root *html.Node) *Page {
+	pageUrl := result.FinalUrl
+	page := &Page{Job: job, Result: result, Url: pageUrl, Base: pageUrl}
+	if href, ok := findBaseHref(root); ok {
+		if base, err := pageUrl.Parse(href); err == nil {
+			page.Base = base
+		}
+	}
+	return page
+}
+
+// Resolve returns the absolute form of a link found on the page, using RFC
+// 3986 reference resolution against the page's base. ok is false if the link
+// can't be parsed.
+func (p *Page) Resolve(ref string) (resolved string, ok bool) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return "", false
+	}
+	parsed, err := p.Base.Parse(ref)
+	if err != nil {
+		return "", false
+	}
+	return parsed.String(), true
+}
+
+// Follow returns the Job for a link found on the page, one level deeper than
+// the page itself.
+func (p *Page) Follow(link string) jobs.Job {
+	return p.Job.Follow(link, p.Url.String())
+}
+
+// findBaseHref returns the href of the first <base> element that has one, as
+// per the html spec.
+func findBaseHref(n *html.Node) (string, bool) {
+	if n == nil {
+		return "", false
+	}
+	if n.Type == html.ElementNode && n.DataAtom == atom.Base {
+		for _, attr := range n.Attr {
+			if attr.Key == "href" {
+				return attr.Val, true
+			}
+		}
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if href, ok := findBaseHref(child); ok {
+			return href, true
+		}
+	}
+	return "", false
+}
+
+// findTitle returns the text of the document's <title>, with its whitespace
+// collapsed
+func findTitle(n *html.Node) string {
+	if n == nil {
+		return ""
+	}
+	if n.Type == html.ElementNode && n.DataAtom == atom.Svg {
+		return ""
+	}
+	if n.Type == html.ElementNode && n.DataAtom == atom.Title {
+		var text strings.Builder
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.TextNode {
+				text.WriteString(child.Data)
+			}
+		}
+		return strings.Join(strings.Fields(text.String()), " ")
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if title := findTitle(child); title != "" {
+			return title
+		}
+	}
+	return ""
+}
+
+// countLinks returns the number of distinct urls that the page links to,
+// from the src and href attributes of an HTML document, or the references in
+// a stylesheet if there is no node tree.
+func countLinks(root *html.Node, page *Page) int {
+	if root == nil {
+		if page.Result.MediaType() == "text/css" {
+			return len(cssUrls(page))
+		}
+		return 0
+	}
+
+	targets := map[string]bool{}
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		for _, attr := range n.Attr {
+			if attr.Key == "src" || attr.Key == "href" {
+				if resolved, ok := page.Resolve(attr.Val); ok {
+					targets[resolved] = true
+				}
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			collect(child)
+		}
+	}
+	collect(root)
+	return len(targets)
+}
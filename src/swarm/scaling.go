@@ -0,0 +1,121 @@
+package swarm
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Scaling is the policy a Swarm uses to grow and shrink while it runs. Every
+// Interval the swarm compares the backlog with the number of workers: if
+// there are more jobs waiting than workers it spawns more, up to Max, and if
+// there are none waiting it retires one, down to Min. If the crawls over the
+// last interval took longer than MaxLatency on average, the servers are
+// struggling, so the swarm retires a worker rather than adding any.
+type Scaling struct {
+	Min        int
+	Max        int
+	Interval   time.Duration
+	MaxLatency time.Duration
+}
+
+// Enabled is true if the policy allows the swarm to change size at all
+func (sc Scaling) Enabled() bool {
+	return sc.Max > sc.Min && sc.Interval > 0
+}
+
+// latencyWindow accumulates how long crawls take between scaling decisions
+type latencyWindow struct {
+	mutex sync.Mutex
+	total time.Duration
+	count int
+}
+
+// Observe records the duration of a single crawl
+func (lw *latencyWindow) Observe(duration time.Duration) {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+	lw.total += duration
+	lw.count++
+}
+
+// Drain returns the mean duration observed since the last call and resets
+func (lw *latencyWindow) Drain() (mean time.Duration, count int) {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+	if lw.count > 0 {
+		mean = lw.total / time.Duration(lw.count)
+	}
+	count = lw.count
+	lw.total, lw.count = 0, 0
+	return mean, count
+}
+
+// autoscale applies the Scaling policy every interval until stopped
+func (s *Swarm) autoscale(ctx context.Context, stop <-chan Signal) {
+	ticker := time.NewTicker(s.scaling.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.rescale(ctx)
+		}
+	}
+}
+
+// rescale makes a single scaling decision
+func (s *Swarm) rescale(ctx context.Context) {
+	backlog := s.incoming.Length()
+	latency, samples := s.latency.Drain()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.finished || s.stopping || ctx.Err() != nil {
+		return
+	}
+
+	size := 0
+	for _, worker := range s.workers {
+		if !worker.retiring {
+			size++
+		}
+	}
+	slow := s.scaling.MaxLatency > 0 && samples > 0 && latency > s.scaling.MaxLatency
+
+	switch {
+	case slow && size > s.scaling.Min:
+		log.Printf("Swarm: mean latency %v, retiring a worker", latency)
+		s.retireWorker()
+	case !slow && backlog > size && size < s.scaling.Max:
+		// Grow towards the backlog, but at most doubling each interval
+		grow := backlog - size
+		if grow > size {
+			grow = size
+		}
+		if grow > s.scaling.Max-size {
+			grow = s.scaling.Max - size
+		}
+		log.Printf("Swarm: %d jobs waiting, spawning %d workers", backlog, grow)
+		for i := 0; i < grow; i++ {
+			crawler := s.Spawner()
+			s.Crawlers = append(s.Crawlers, crawler)
+			s.startWorker(ctx, crawler)
+		}
+	case backlog == 0 && size > s.scaling.Min:
+		log.Println("Swarm: no jobs waiting, retiring a worker")
+		s.retireWorker()
+	}
+}
+
+// retireWorker tells the most recently started worker to stop once it has
+// finished its current job. It must be called with the mutex held.
+func (s *Swarm) retireWorker() {
+	for i := len(s.workers) - 1; i >= 0; i-- {
+		if s.workers[i].Retire() {
+			return
+		}
+	}
+}
@@ -2,14 +2,20 @@ package swarm
 
 import (
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 	"log"
 	"os"
+	"strings"
+	"tjweldon/spider/src/jobs"
+	"tjweldon/spider/src/links"
 	"tjweldon/spider/src/messaging"
 )
 
 var Urls []string
 
-type NodeScraper func(node *html.Node)
+// NodeScraper functions are applied to the nodes of a page, along with the Page
+// they were found on.
+type NodeScraper func(node *html.Node, page *Page)
 
 // Then composes scraping operations sequentially:
 //
@@ -18,15 +24,15 @@ type NodeScraper func(node *html.Node)
 // The scraper assigned to ns executes the scrapers in
 // the order ns1, ns2, ns3
 func (ns1 NodeScraper) Then(ns2 NodeScraper) NodeScraper {
-	return func(node *html.Node) {
-		ns1(node)
-		ns2(node)
+	return func(node *html.Node, page *Page) {
+		ns1(node, page)
+		ns2(node, page)
 	}
 }
 
 // DumpHtml is a scraper largely for debugging. It renders the current node (and
 // all children) to stdout. Has a significant performance penalty.
-func DumpHtml(n *html.Node) {
+func DumpHtml(n *html.Node, _ *Page) {
 	err := html.Render(os.Stdout, n)
 	if err != nil {
 		log.Fatal(err)
@@ -38,26 +44,93 @@ func DumpHtml(n *html.Node) {
 }
 
 // ScrapeUrls puts all of the urls it finds into a global variable Urls that
-// can be dumped out at the end.
-func ScrapeUrls(n *html.Node) {
+// can be dumped out at the end. Relative urls are resolved against the page.
+func ScrapeUrls(n *html.Node, page *Page) {
 	for _, attr := range n.Attr {
 		if attr.Key == "src" || attr.Key == "href" {
-			Urls = append(Urls, attr.Val)
+			if resolved, ok := page.Resolve(attr.Val); ok {
+				Urls = append(Urls, resolved)
+			}
 		}
 	}
 }
 
 // RecoverUrls is the the part that scrapers play in the self-perpetuation of
 // the swarm. This is a factory for NodeScraper functions that pass any urls
-// they find to the passed Dispatcher.
-func RecoverUrls(dispatcher messaging.Dispatcher[string]) NodeScraper {
-	return func(n *html.Node) {
+// they find to the passed Dispatcher, resolved against the page they were
+// found on and one level deeper than it.
+func RecoverUrls(dispatcher messaging.Dispatcher[jobs.Job]) NodeScraper {
+	return func(n *html.Node, page *Page) {
 		for _, attr := range n.Attr {
 			if attr.Key == "src" || attr.Key == "href" {
-				if !dispatcher.Dispatch(attr.Val) {
+				resolved, ok := page.Resolve(attr.Val)
+				if !ok {
+					continue
+				}
+				if !dispatcher.Dispatch(page.Follow(resolved)) {
 					return
 				}
 			}
 		}
 	}
 }
+
+// RecordLinks is a factory for NodeScraper functions that pass every link
+// they find to the passed Dispatcher, along with where on the page it was
+// found. Unlike RecoverUrls, each reference is recorded, not just each url.
+func RecordLinks(dispatcher messaging.Dispatcher[links.Link]) NodeScraper {
+	return func(n *html.Node, page *Page) {
+		for _, attr := range n.Attr {
+			if attr.Key == "src" || attr.Key == "href" {
+				resolved, ok := page.Resolve(attr.Val)
+				if !ok {
+					continue
+				}
+				dispatcher.Dispatch(links.Link{
+					Source:    page.Url.String(),
+					Target:    resolved,
+					Element:   n.Data,
+					Attribute: attr.Key,
+					Text:      anchorText(n),
+					Rel:       relOf(n),
+				})
+			}
+		}
+	}
+}
+
+// relOf returns the rel attribute of a link, with its whitespace collapsed
+func relOf(n *html.Node) string {
+	for _, attr := range n.Attr {
+		if attr.Key == "rel" {
+			return strings.Join(strings.Fields(strings.ToLower(attr.Val)), " ")
+		}
+	}
+	return ""
+}
+
+// anchorText returns the text of a link, which for an image is its alt text
+func anchorText(n *html.Node) string {
+	if n.DataAtom == atom.Img {
+		for _, attr := range n.Attr {
+			if attr.Key == "alt" {
+				return strings.TrimSpace(attr.Val)
+			}
+		}
+		return ""
+	}
+
+	var text strings.Builder
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			text.WriteString(n.Data)
+			text.WriteString(" ")
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			collect(child)
+		}
+	}
+	collect(n)
+	return strings.Join(strings.Fields(text.String()), " ")
+}
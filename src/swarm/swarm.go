@@ -1,11 +1,16 @@
 package swarm
 
 import (
+	"context"
 	"log"
+	"sync"
+	"tjweldon/spider/src/jobs"
 	"tjweldon/spider/src/messaging"
+	"tjweldon/spider/src/util"
 )
 
-const SwarmSize = 5
+// DefaultSwarmSize is the number of workers a swarm runs if not told otherwise
+const DefaultSwarmSize = 5
 
 type Spawner func() *Crawler
 
@@ -13,46 +18,145 @@ type Spawner func() *Crawler
 // state and coordinates them.
 type Swarm struct {
 	Spawner    Spawner
-	Crawlers   [SwarmSize]*Crawler
-	Jobs       []string
-	incoming   messaging.Backlog[string]
-	dispatcher messaging.Dispatcher[string]
+	Crawlers   []*Crawler
+	Jobs       []jobs.Job
+	incoming   messaging.Backlog[jobs.Job]
+	dispatcher messaging.Dispatcher[jobs.Job]
+
+	// scaling, if enabled, lets the swarm add and retire workers while it runs
+	scaling Scaling
+	latency *latencyWindow
+
+	// mutex guards the running workers, which change as the swarm scales
+	mutex    sync.Mutex
+	workers  []*Worker
+	nextId   int
+	stopping bool
+	finished bool
+	allDone  chan Signal
 }
 
-// NewSwarm returns a pointer to a new spawn instance
-func NewSwarm(spawner Spawner) *Swarm {
+// NewSwarm returns a pointer to a new spawn instance with size crawlers
+func NewSwarm(spawner Spawner, size int) *Swarm {
 	log.Println("Initialising Swarm")
 
-	crawlers := [SwarmSize]*Crawler{}
+	if size < 1 {
+		size = 1
+	}
+	crawlers := make([]*Crawler, size)
 	for i := range crawlers {
 		crawlers[i] = spawner()
 	}
 
 	swarm := &Swarm{
-		Jobs:     []string{},
+		Jobs:     []jobs.Job{},
 		Crawlers: crawlers,
 		Spawner:  spawner,
+		latency:  &latencyWindow{},
+		allDone:  make(chan Signal),
 	}
 	return swarm
 }
 
 // Spawn runs the swarm, starting the feedback loop with
-// whatever jobs have been seeded.
-func (s *Swarm) Spawn() {
-	workers := [SwarmSize]*Worker{}
-	for i, crawler := range s.Crawlers {
-		workers[i] = crawler.Work(s.incoming, i)
+// whatever jobs have been seeded. If the backlog can be drained,
+// the swarm finishes as soon as there are no jobs waiting and
+// no worker is mid-crawl. Cancelling the context aborts the
+// crawls in progress, whereas Stop lets them finish.
+func (s *Swarm) Spawn(ctx context.Context) {
+	s.mutex.Lock()
+	for _, crawler := range s.Crawlers {
+		s.startWorker(ctx, crawler)
 	}
+	s.mutex.Unlock()
 
-	for _, worker := range workers {
+	if drainer, ok := s.incoming.(messaging.Drainer); ok {
+		drainer.Drain()
+	}
+
+	stopScaling := make(chan Signal)
+	if s.scaling.Enabled() {
+		go s.autoscale(ctx, stopScaling)
+	}
+
+	<-s.allDone
+	close(stopScaling)
+	s.dispatcher.Close()
+
+	// If the swarm was stopped early there may be jobs left, which are
+	// discarded so that any other consumers of the backlog can finish. Once
+	// cancelled, the backlog is stopped rather than waited on to hand them
+	// out.
+	if stopper, ok := s.incoming.(messaging.Stopper); ok && ctx.Err() != nil {
+		stopper.Stop()
+	}
+	util.AwaitClosure[jobs.Job](s.incoming.Channel())
+}
+
+// Stop retires every worker, so that the swarm finishes as soon as the
+// crawls in progress are done rather than when the backlog runs out.
+func (s *Swarm) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.stopping = true
+	for _, worker := range s.workers {
+		worker.Retire()
+	}
+}
+
+// startWorker sets a crawler working on the backlog. It must be called with
+// the mutex held.
+func (s *Swarm) startWorker(ctx context.Context, crawler *Crawler) {
+	worker := crawler.getWorker(s.incoming, s.nextId)
+	worker.latency = s.latency
+	s.nextId++
+	s.workers = append(s.workers, worker)
+
+	go worker.Run(ctx)
+	log.Printf("Worker %d: Worker Started", worker.id)
+
+	go func() {
 		worker.AwaitCompletion()
 		worker.Die()
+		s.workerFinished(worker)
+	}()
+}
+
+// workerFinished removes a worker that has stopped from the swarm. When the
+// last one stops the swarm is finished, and no more can be started.
+func (s *Swarm) workerFinished(worker *Worker) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i, running := range s.workers {
+		if running == worker {
+			s.workers = append(s.workers[:i], s.workers[i+1:]...)
+			break
+		}
+	}
+	for i, crawler := range s.Crawlers {
+		if crawler == worker.crawler {
+			s.Crawlers = append(s.Crawlers[:i], s.Crawlers[i+1:]...)
+			break
+		}
+	}
+
+	if len(s.workers) == 0 && !s.finished {
+		s.finished = true
+		close(s.allDone)
 	}
-	s.dispatcher.Close()
+}
+
+// Size returns the number of workers currently running
+func (s *Swarm) Size() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.workers)
 }
 
 // workersDone counts the number of workers reporting completion
-func (s *Swarm) workersDone(workers [SwarmSize]*Worker) (count int) {
+func (s *Swarm) workersDone(workers []*Worker) (count int) {
 	for _, worker := range workers {
 		if worker.IsDone() {
 			count++
@@ -75,16 +179,24 @@ func (s *Swarm) countRunning() int {
 }
 
 // SetIncoming fluently sets the backlog of work for the swarm
-func (s *Swarm) SetIncoming(incoming messaging.Backlog[string]) *Swarm {
+func (s *Swarm) SetIncoming(incoming messaging.Backlog[jobs.Job]) *Swarm {
 	s.incoming = incoming
 	return s
 }
 
-// SetDispatcher allows the dispatcher that relays found URLs back to the job queue
-func (s *Swarm) SetDispatcher(dispatcher messaging.Dispatcher[string], seedJobs ...string) *Swarm {
+// SetScaling fluently sets the limits within which the swarm may add and
+// retire workers while it runs.
+func (s *Swarm) SetScaling(scaling Scaling) *Swarm {
+	s.scaling = scaling
+	return s
+}
+
+// SetDispatcher allows the dispatcher that relays found URLs back to the job queue.
+// Each of the seedUrls is dispatched as a job at depth zero.
+func (s *Swarm) SetDispatcher(dispatcher messaging.Dispatcher[jobs.Job], seedUrls ...string) *Swarm {
 	s.dispatcher = dispatcher
-	for _, job := range seedJobs {
-		s.dispatcher.Dispatch(job)
+	for _, seedUrl := range seedUrls {
+		s.dispatcher.Dispatch(jobs.Seed(seedUrl))
 	}
 	return s
 }
@@ -0,0 +1,271 @@
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"tjweldon/spider/src/fetch"
+	"tjweldon/spider/src/jobs"
+	"tjweldon/spider/src/messaging"
+	"tjweldon/spider/src/urls"
+)
+
+// stubPage is a page served by a stubFetcher. It fails with Status the first
+// Failures times it is fetched, or every time if Failures is negative. If it
+// has a Redirect, fetching it gets the page at that url instead.
+type stubPage struct {
+	Links    []string
+	Status   int
+	Failures int
+	Redirect string
+}
+
+// stubFetcher serves pages from memory, counting the fetches of each url.
+// Urls it doesn't know are a 404.
+type stubFetcher struct {
+	pages map[string]*stubPage
+
+	// block, if set, holds up every fetch until it is closed or the fetch's
+	// context is done, and started is sent the url of each fetch held up
+	block   chan Signal
+	started chan string
+
+	mutex   sync.Mutex
+	fetches map[string]int
+}
+
+func newStubFetcher(pages map[string]*stubPage) *stubFetcher {
+	return &stubFetcher{pages: pages, fetches: map[string]int{}}
+}
+
+// Fetch is the implementation of fetch.Fetcher
+func (sf *stubFetcher) Fetch(ctx context.Context, target string) *fetch.Result {
+	sf.mutex.Lock()
+	sf.fetches[target]++
+	attempt := sf.fetches[target]
+	sf.mutex.Unlock()
+
+	result := &fetch.Result{Url: target, Started: time.Now(), Header: http.Header{}}
+	if sf.block != nil {
+		if sf.started != nil {
+			sf.started <- target
+		}
+		select {
+		case <-sf.block:
+		case <-ctx.Done():
+			result.Err = ctx.Err()
+			return result
+		}
+	}
+
+	page, ok := sf.pages[target]
+	if ok && page.Redirect != "" {
+		result.Redirects = []fetch.Redirect{{From: target, To: page.Redirect, Status: http.StatusMovedPermanently}}
+		target = page.Redirect
+		page, ok = sf.pages[target]
+	}
+	result.FinalUrl, _ = url.Parse(target)
+	switch {
+	case !ok:
+		result.Status = http.StatusNotFound
+	case page.Failures < 0 || attempt <= page.Failures:
+		result.Status = page.Status
+	default:
+		result.Status = http.StatusOK
+		result.Header.Set("Content-Type", "text/html")
+		var body strings.Builder
+		body.WriteString("<html><body>")
+		for _, link := range page.Links {
+			fmt.Fprintf(&body, `<a href="%s">link</a>`, link)
+		}
+		body.WriteString("</body></html>")
+		result.Body = []byte(body.String())
+	}
+	if result.Status != http.StatusOK {
+		result.Err = &fetch.StatusError{Status: result.Status}
+	}
+	return result
+}
+
+// Fetches returns the number of times the url was fetched
+func (sf *stubFetcher) Fetches(target string) int {
+	sf.mutex.Lock()
+	defer sf.mutex.Unlock()
+	return sf.fetches[target]
+}
+
+// testSwarm wires a swarm up to the stub the way main does: a HostScheduler
+// backlog, deduplicated dispatch of the links found and retries re-enqueued
+// on the scheduler.
+func testSwarm(fetcher *stubFetcher, workers int, seeds ...string) *Swarm {
+	dispatcher, backlog := messaging.
+		NewHostScheduler[jobs.Job](jobs.Job.Host, 0, 0).
+		SetReadyAt(jobs.Job.ReadyAt).
+		Split()
+	deduplicated := messaging.WithDeDuplicationBy[jobs.Job, string](dispatcher, jobs.Job.Key)
+	retry := fetch.RetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+	spawner := func() *Crawler {
+		return NewCrawler().
+			SetFetcher(fetcher).
+			SetRetry(retry, dispatcher).
+			AddScraper(RecoverUrls(deduplicated), HasAttrs("href"))
+	}
+	return NewSwarm(spawner, workers).
+		SetIncoming(backlog).
+		SetDispatcher(deduplicated, seeds...)
+}
+
+// spawnWithin runs the swarm, failing the test if Spawn doesn't return in
+// time
+func spawnWithin(t *testing.T, ctx context.Context, s *Swarm, timeout time.Duration) {
+	t.Helper()
+	done := make(chan Signal)
+	go func() {
+		s.Spawn(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatalf("Spawn did not return within %v", timeout)
+	}
+}
+
+func TestSpawnCrawlsEveryPageOnce(t *testing.T) {
+	fetcher := newStubFetcher(map[string]*stubPage{
+		"http://a.test/":  {Links: []string{"/x", "/y", "http://b.test/"}},
+		"http://a.test/x": {Links: []string{"/", "/y", "/x"}},
+		"http://a.test/y": {Links: []string{"/x", "/missing"}},
+		"http://b.test/":  {Links: []string{"http://a.test/", "/z"}},
+		"http://b.test/z": {},
+	})
+	spawnWithin(t, context.Background(), testSwarm(fetcher, 3, "http://a.test/"), 5*time.Second)
+
+	for _, target := range []string{
+		"http://a.test/", "http://a.test/x", "http://a.test/y", "http://a.test/missing",
+		"http://b.test/", "http://b.test/z",
+	} {
+		if got := fetcher.Fetches(target); got != 1 {
+			t.Errorf("%s fetched %d times, want 1", target, got)
+		}
+	}
+}
+
+func TestSpawnRetries(t *testing.T) {
+	fetcher := newStubFetcher(map[string]*stubPage{
+		"http://a.test/":             {Links: []string{"/flaky", "/broken", "/fine"}},
+		"http://a.test/flaky":        {Links: []string{"/behind-flaky"}, Status: http.StatusServiceUnavailable, Failures: 2},
+		"http://a.test/broken":       {Status: http.StatusInternalServerError, Failures: -1},
+		"http://a.test/fine":         {},
+		"http://a.test/behind-flaky": {},
+	})
+	spawnWithin(t, context.Background(), testSwarm(fetcher, 2, "http://a.test/"), 5*time.Second)
+
+	want := map[string]int{
+		"http://a.test/":             1,
+		"http://a.test/flaky":        3,
+		"http://a.test/broken":       3,
+		"http://a.test/fine":         1,
+		"http://a.test/behind-flaky": 1,
+	}
+	for target, fetches := range want {
+		if got := fetcher.Fetches(target); got != fetches {
+			t.Errorf("%s fetched %d times, want %d", target, got, fetches)
+		}
+	}
+}
+
+func TestSpawnCrawlsRedirectsToTheSameCanonicalUrl(t *testing.T) {
+	fetcher := newStubFetcher(map[string]*stubPage{
+		"http://a.test/":          {Links: []string{"/docs"}},
+		"http://a.test/docs":      {Redirect: "http://a.test/docs/"},
+		"http://a.test/docs/":     {Links: []string{"/docs/page"}},
+		"http://a.test/docs/page": {},
+	})
+	canonicalise := urls.NewCanonicaliser().SetFoldTrailingSlash(true).PreProcessor()
+	dispatcher, backlog := messaging.
+		NewHostScheduler[jobs.Job](jobs.Job.Host, 0, 0).
+		Split()
+	claimer := messaging.WithPreProcessing[jobs.Job](
+		messaging.WithDeDuplicationBy[jobs.Job, string](dispatcher, jobs.Job.Key),
+		jobs.PreProcessUrl(canonicalise),
+	)
+	spawner := func() *Crawler {
+		return NewCrawler().
+			SetFetcher(fetcher).
+			SetClaimer(claimer).
+			SetCanonicaliser(canonicalise).
+			AddScraper(RecoverUrls(claimer), HasAttrs("href"))
+	}
+	s := NewSwarm(spawner, 2).SetIncoming(backlog).SetDispatcher(claimer, "http://a.test/")
+	spawnWithin(t, context.Background(), s, 5*time.Second)
+
+	if got := fetcher.Fetches("http://a.test/docs/page"); got != 1 {
+		t.Errorf("the link on the redirected page was fetched %d times, want 1", got)
+	}
+}
+
+func TestSpawnWithEmptyFrontier(t *testing.T) {
+	fetcher := newStubFetcher(map[string]*stubPage{})
+	spawnWithin(t, context.Background(), testSwarm(fetcher, 3), time.Second)
+}
+
+func TestSpawnWithOnlyDeadEnds(t *testing.T) {
+	fetcher := newStubFetcher(map[string]*stubPage{})
+	spawnWithin(t, context.Background(), testSwarm(fetcher, 3, "http://a.test/", "http://b.test/"), time.Second)
+
+	if fetcher.Fetches("http://a.test/") != 1 || fetcher.Fetches("http://b.test/") != 1 {
+		t.Errorf("seeds fetched %d and %d times, want 1 each",
+			fetcher.Fetches("http://a.test/"), fetcher.Fetches("http://b.test/"))
+	}
+}
+
+func TestSpawnCancelled(t *testing.T) {
+	fetcher := newStubFetcher(map[string]*stubPage{
+		"http://a.test/": {Links: []string{"/x"}},
+	})
+	fetcher.block = make(chan Signal)
+	fetcher.started = make(chan string, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-fetcher.started
+		cancel()
+	}()
+	spawnWithin(t, ctx, testSwarm(fetcher, 2, "http://a.test/"), 5*time.Second)
+
+	if got := fetcher.Fetches("http://a.test/x"); got != 0 {
+		t.Errorf("a link on a cancelled page was fetched %d times", got)
+	}
+}
+
+func TestStopLetsCrawlsInProgressFinish(t *testing.T) {
+	fetcher := newStubFetcher(map[string]*stubPage{
+		"http://a.test/":  {Links: []string{"/x"}},
+		"http://a.test/x": {},
+	})
+	fetcher.block = make(chan Signal)
+	fetcher.started = make(chan string, 1)
+
+	s := testSwarm(fetcher, 2, "http://a.test/")
+	go func() {
+		<-fetcher.started
+		s.Stop()
+		close(fetcher.block)
+	}()
+	spawnWithin(t, context.Background(), s, 5*time.Second)
+
+	if got := fetcher.Fetches("http://a.test/"); got != 1 {
+		t.Errorf("seed fetched %d times, want 1", got)
+	}
+	if got := fetcher.Fetches("http://a.test/x"); got != 0 {
+		t.Errorf("a link found after stopping was fetched %d times", got)
+	}
+}
@@ -0,0 +1,308 @@
+package urls
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"tjweldon/spider/src/messaging"
+)
+
+// DefaultTrackingParams are the query parameters that are dropped by a new
+// Canonicaliser. A trailing * matches any parameter with that prefix.
+var DefaultTrackingParams = []string{
+	"utm_*", "fbclid", "gclid", "dclid", "msclkid", "yclid",
+	"mc_cid", "mc_eid", "igshid", "_ga", "_gl",
+}
+
+// defaultPorts are the ports that are implied by each scheme, and so can be
+// dropped from the host.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Canonicaliser rewrites urls into a canonical form so that different ways of
+// writing the same address compare equal, which is what deduplication needs.
+type Canonicaliser struct {
+	trackingParams    []string
+	stripFragment     bool
+	sortQuery         bool
+	foldTrailingSlash bool
+}
+
+// NewCanonicaliser returns a Canonicaliser with the default settings: strip
+// fragments, sort the query and drop the DefaultTrackingParams, but leave
+// trailing slashes alone.
+func NewCanonicaliser() *Canonicaliser {
+	return &Canonicaliser{
+		trackingParams: append([]string{}, DefaultTrackingParams...),
+		stripFragment:  true,
+		sortQuery:      true,
+	}
+}
+
+// SetTrackingParams is a fluent setter for the query parameters to drop,
+// replacing the defaults.
+func (c *Canonicaliser) SetTrackingParams(params ...string) *Canonicaliser {
+	c.trackingParams = params
+	return c
+}
+
+// AddTrackingParams is a fluent setter that drops further query parameters
+// on top of those already configured.
+func (c *Canonicaliser) AddTrackingParams(params ...string) *Canonicaliser {
+	c.trackingParams = append(c.trackingParams, params...)
+	return c
+}
+
+// SetStripFragment is a fluent setter for whether the #fragment is removed.
+func (c *Canonicaliser) SetStripFragment(strip bool) *Canonicaliser {
+	c.stripFragment = strip
+	return c
+}
+
+// SetSortQuery is a fluent setter for whether query parameters are sorted.
+func (c *Canonicaliser) SetSortQuery(sortQuery bool) *Canonicaliser {
+	c.sortQuery = sortQuery
+	return c
+}
+
+// SetFoldTrailingSlash is a fluent setter for whether a trailing slash is
+// removed from paths other than the root, so /x/ and /x are the same page.
+func (c *Canonicaliser) SetFoldTrailingSlash(fold bool) *Canonicaliser {
+	c.foldTrailingSlash = fold
+	return c
+}
+
+// Canonicalise returns the canonical form of the url, or an error if it can't
+// be parsed.
+func (c *Canonicaliser) Canonicalise(rawUrl string) (string, error) {
+	parsed, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil {
+		return "", err
+	}
+
+	// Opaque urls such as mailto: have no structure to normalise
+	if parsed.Opaque != "" {
+		return strings.ToLower(parsed.Scheme) + ":" + parsed.Opaque, nil
+	}
+
+	var builder strings.Builder
+	scheme := strings.ToLower(parsed.Scheme)
+	if scheme != "" {
+		builder.WriteString(scheme + ":")
+	}
+	if parsed.Host != "" || scheme != "" {
+		builder.WriteString("//")
+		if parsed.User != nil {
+			builder.WriteString(parsed.User.String() + "@")
+		}
+		builder.WriteString(canonicalHost(scheme, parsed.Host))
+	}
+
+	builder.WriteString(c.canonicalPath(parsed))
+
+	if query := c.canonicalQuery(parsed.RawQuery); query != "" {
+		builder.WriteString("?" + query)
+	}
+
+	if !c.stripFragment && parsed.Fragment != "" {
+		builder.WriteString("#" + parsed.EscapedFragment())
+	}
+
+	return builder.String(), nil
+}
+
+// PreProcessor returns the Canonicaliser as a messaging.PreProcessor so it can
+// be chained with messaging.WithPreProcessing. Urls that can't be parsed are
+// passed through untouched for the validators to deal with.
+func (c *Canonicaliser) PreProcessor() messaging.PreProcessor[string] {
+	return func(item string) string {
+		canonical, err := c.Canonicalise(item)
+		if err != nil {
+			return item
+		}
+		return canonical
+	}
+}
+
+// canonicalHost lowercases the host and drops the port if it is the default
+// for the scheme.
+func canonicalHost(scheme, host string) string {
+	host = strings.ToLower(host)
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		// No port
+		return strings.TrimSuffix(host, ".")
+	}
+	hostname = strings.TrimSuffix(hostname, ".")
+	if port == "" || defaultPorts[scheme] == port {
+		if strings.Contains(hostname, ":") {
+			return "[" + hostname + "]"
+		}
+		return hostname
+	}
+	return net.JoinHostPort(hostname, port)
+}
+
+// canonicalPath normalises the percent encoding and dot segments of the path,
+// and folds the trailing slash if configured to.
+func (c *Canonicaliser) canonicalPath(parsed *url.URL) string {
+	path := normalisePercentEncoding(parsed.EscapedPath())
+	path = removeDotSegments(path)
+	if path == "" && parsed.Host != "" {
+		path = "/"
+	}
+	if c.foldTrailingSlash && len(path) > 1 {
+		path = strings.TrimRight(path, "/")
+		if path == "" {
+			path = "/"
+		}
+	}
+	return path
+}
+
+// canonicalQuery drops the tracking parameters and normalises and sorts the
+// rest. The parameters are split by hand rather than with url.ParseQuery so
+// that the original encoding of each one survives.
+func (c *Canonicaliser) canonicalQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	var params []string
+	for _, param := range strings.Split(rawQuery, "&") {
+		if param == "" {
+			continue
+		}
+		key, _, _ := strings.Cut(param, "=")
+		if c.isTrackingParam(key) {
+			continue
+		}
+		params = append(params, normalisePercentEncoding(param))
+	}
+
+	if c.sortQuery {
+		sort.SliceStable(params, func(i, j int) bool {
+			keyI, valueI, _ := strings.Cut(params[i], "=")
+			keyJ, valueJ, _ := strings.Cut(params[j], "=")
+			if keyI != keyJ {
+				return keyI < keyJ
+			}
+			return valueI < valueJ
+		})
+	}
+
+	return strings.Join(params, "&")
+}
+
+// isTrackingParam matches a raw query key against the tracking parameters
+func (c *Canonicaliser) isTrackingParam(rawKey string) bool {
+	key, err := url.QueryUnescape(rawKey)
+	if err != nil {
+		key = rawKey
+	}
+	key = strings.ToLower(key)
+
+	for _, pattern := range c.trackingParams {
+		pattern = strings.ToLower(pattern)
+		if prefix := strings.TrimSuffix(pattern, "*"); prefix != pattern {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if key == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// normalisePercentEncoding decodes escapes of unreserved characters, which
+// never need escaping, uppercases the hex digits of every other escape and
+// escapes any bytes that are not allowed in a url at all.
+func normalisePercentEncoding(s string) string {
+	var builder strings.Builder
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if b == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			decoded := unhex(s[i+1])<<4 | unhex(s[i+2])
+			if isUnreserved(decoded) {
+				builder.WriteByte(decoded)
+			} else {
+				builder.WriteString("%" + strings.ToUpper(s[i+1:i+3]))
+			}
+			i += 2
+			continue
+		}
+		if isAllowed(b) {
+			builder.WriteByte(b)
+		} else {
+			builder.WriteString(fmt.Sprintf("%%%02X", b))
+		}
+	}
+	return builder.String()
+}
+
+// removeDotSegments applies the algorithm in RFC 3986 section 5.2.4 to an
+// absolute path.
+func removeDotSegments(path string) string {
+	if !strings.Contains(path, ".") {
+		return path
+	}
+
+	segments := strings.Split(path, "/")
+	output := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch segment {
+		case ".":
+			if last {
+				output = append(output, "")
+			}
+		case "..":
+			if len(output) > 1 {
+				output = output[:len(output)-1]
+			}
+			if last {
+				output = append(output, "")
+			}
+		default:
+			output = append(output, segment)
+		}
+	}
+
+	result := strings.Join(output, "/")
+	if strings.HasPrefix(path, "/") && !strings.HasPrefix(result, "/") {
+		result = "/" + result
+	}
+	return result
+}
+
+// isUnreserved is true for the characters RFC 3986 says never need escaping
+func isUnreserved(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' ||
+		b == '-' || b == '.' || b == '_' || b == '~'
+}
+
+// isAllowed is true for the characters that may appear unescaped in a path or
+// query
+func isAllowed(b byte) bool {
+	return isUnreserved(b) || strings.IndexByte("!$&'()*+,;=:@/?", b) >= 0
+}
+
+func isHex(b byte) bool {
+	return '0' <= b && b <= '9' || 'a' <= b && b <= 'f' || 'A' <= b && b <= 'F'
+}
+
+func unhex(b byte) byte {
+	switch {
+	case '0' <= b && b <= '9':
+		return b - '0'
+	case 'a' <= b && b <= 'f':
+		return b - 'a' + 10
+	default:
+		return b - 'A' + 10
+	}
+}
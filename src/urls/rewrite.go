@@ -0,0 +1,16 @@
+package urls
+
+import (
+	"regexp"
+	"tjweldon/spider/src/messaging"
+)
+
+// Rewrite is a factory for PreProcessors that replace the matches of pattern
+// in a url with replacement, which may refer to submatches as in
+// regexp.Regexp.ReplaceAllString. Use it to move urls onto the address they
+// should be crawled at, such as from http to https.
+func Rewrite(pattern *regexp.Regexp, replacement string) messaging.PreProcessor[string] {
+	return func(rawUrl string) string {
+		return pattern.ReplaceAllString(rawUrl, replacement)
+	}
+}
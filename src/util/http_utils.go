@@ -3,6 +3,8 @@ package util
 import (
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
 // MustGet is a wrapper around http.Get that exits the program if there is an
@@ -16,13 +18,12 @@ func MustGet(url string) *http.Response {
 	return resp
 }
 
-// GetOrNil returns a response pointer if the request was successful, nil
-// otherwise.
-func GetOrNil(url string) *http.Response {
-	resp, err := http.Get(url)
+// Host returns the lowercase host (and port, if there is one) of a url, or
+// the url itself if it can't be parsed.
+func Host(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
 	if err != nil {
-		return nil
+		return rawUrl
 	}
-
-	return resp
+	return strings.ToLower(parsed.Host)
 }
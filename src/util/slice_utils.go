@@ -0,0 +1,12 @@
+package util
+
+// Contains is a convenience function that returns true if the value is one
+// of the values in the slice.
+func Contains[T comparable](values []T, value T) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}